@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// screenshots and recordings of what the player sees, written to PNGs
+// frames being captured are copied back asynchronously, and encoded by a pool of writers, so capturing doesn't stall the main loop
+
+const SCREENSHOT_KEY = glfw.KeyF12
+
+// how many readbacks can be in flight before the main loop waits on the oldest one
+// this only really happens when recording, as every frame is captured
+
+const CAPTURE_MAX_PENDING = 8
+
+type CaptureJob struct {
+	readback *Readback
+	path     string
+}
+
+type CaptureWrite struct {
+	img  *image.RGBA
+	path string
+}
+
+type Capture struct {
+	state *State
+	dir   string
+
+	// frames being captured are rendered here, and then blitted to the swapchain
+
+	target        *Texture
+	target_width  uint32
+	target_height uint32
+	bind_group    *wgpu.BindGroup
+
+	requested bool
+	prev_key  bool
+
+	screenshot_at []float64 // seconds into the game, in order, at which screenshots are still to be taken
+
+	// when recording, every frame is captured and time goes by a fixed timestep instead of by the clock
+
+	record_fps   float64
+	record_frame int
+
+	pending []*CaptureJob
+
+	// frames waiting to be written, which are queued without limit, so handing them over never stalls the main loop
+
+	writes      []CaptureWrite
+	writes_lock sync.Mutex
+	writes_cond *sync.Cond
+	closed      bool
+	writers     sync.WaitGroup
+}
+
+// frames are recorded if record_fps is positive
+
+func NewCapture(state *State, dir string, screenshot_at []float64, record_fps float64) *Capture {
+	capture := &Capture{
+		state:         state,
+		dir:           dir,
+		screenshot_at: screenshot_at,
+		record_fps:    record_fps,
+	}
+
+	capture.writes_cond = sync.NewCond(&capture.writes_lock)
+
+	for i := 0; i < runtime.NumCPU(); i++ {
+		capture.writers.Add(1)
+		go capture.writer()
+	}
+
+	return capture
+}
+
+func (capture *Capture) writer() {
+	defer capture.writers.Done()
+
+	for {
+		capture.writes_lock.Lock()
+
+		for len(capture.writes) == 0 && !capture.closed {
+			capture.writes_cond.Wait()
+		}
+
+		// once closed, writers only stop when there's nothing left to write
+
+		if len(capture.writes) == 0 {
+			capture.writes_lock.Unlock()
+			return
+		}
+
+		write := capture.writes[0]
+		capture.writes = capture.writes[1:]
+		capture.writes_lock.Unlock()
+
+		if err := writePng(write.path, write.img); err != nil {
+			log.Printf("Can't write capture %s: %v\n", write.path, err)
+		}
+	}
+}
+
+func (capture *Capture) queueWrite(write CaptureWrite) {
+	capture.writes_lock.Lock()
+	capture.writes = append(capture.writes, write)
+	capture.writes_lock.Unlock()
+
+	capture.writes_cond.Signal()
+}
+
+func writePng(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// times are comma-separated seconds, e.g. "5,12.5"
+
+func ParseScreenshotTimes(times string) ([]float64, error) {
+	var parsed []float64
+
+	for _, field := range strings.Split(times, ",") {
+		field = strings.TrimSpace(field)
+
+		if field == "" {
+			continue
+		}
+
+		t, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, fmt.Errorf("screenshot time %q: %v", field, err)
+		}
+
+		parsed = append(parsed, t)
+	}
+
+	sort.Float64s(parsed)
+	return parsed, nil
+}
+
+func (capture *Capture) Recording() bool {
+	return capture.record_fps > 0
+}
+
+func (capture *Capture) Timestep() float32 {
+	return float32(1 / capture.record_fps)
+}
+
+func (capture *Capture) Update() {
+	down := capture.state.win.GetKey(SCREENSHOT_KEY) == glfw.Press
+
+	if down && !capture.prev_key {
+		capture.requested = true
+	}
+
+	capture.prev_key = down
+
+	for len(capture.screenshot_at) > 0 && capture.state.prev_time >= capture.screenshot_at[0] {
+		capture.screenshot_at = capture.screenshot_at[1:]
+		capture.requested = true
+	}
+}
+
+// whether the next frame is to be captured
+
+func (capture *Capture) Wanted() bool {
+	return capture.requested || capture.Recording()
+}
+
+// where the frame being captured should be rendered to instead of the swapchain
+
+func (capture *Capture) View() *wgpu.TextureView {
+	if err := capture.ensureTarget(); err != nil {
+		log.Fatal(err)
+	}
+
+	return capture.target.view
+}
+
+func (capture *Capture) ensureTarget() error {
+	state := capture.state
+	width, height := state.config.Width, state.config.Height
+
+	if capture.target != nil && capture.target_width == width && capture.target_height == height {
+		return nil
+	}
+
+	capture.releaseTarget()
+
+	var err error
+
+	if capture.target, err = NewRenderTextureWithUsage(state, "Capture target", state.config.Format, width, height, wgpu.TextureUsage_CopySrc); err != nil {
+		return err
+	}
+
+	// the blit post effect's pipeline already draws to the swapchain, so it's reused to copy the target over
+
+	post := state.post
+
+	if capture.bind_group, err = state.device.CreateBindGroup(&wgpu.BindGroupDescriptor{
+		Label:  "Bind group (capture)",
+		Layout: post.pipelines["blit"].bind_group_layout,
+		Entries: []wgpu.BindGroupEntry{
+			{
+				Binding:     0,
+				TextureView: capture.target.view,
+			},
+			{
+				Binding: 1,
+				Sampler: capture.target.sampler,
+			},
+			{
+				Binding: 2,
+				Buffer:  post.blit.uniform_buf,
+				Size:    wgpu.WholeSize,
+			},
+		},
+	}); err != nil {
+		capture.releaseTarget()
+		return err
+	}
+
+	capture.target_width = width
+	capture.target_height = height
+
+	return nil
+}
+
+func (capture *Capture) releaseTarget() {
+	if capture.bind_group != nil {
+		capture.bind_group.Release()
+		capture.bind_group = nil
+	}
+
+	if capture.target != nil {
+		capture.target.Release()
+		capture.target = nil
+	}
+}
+
+// copy the frame being captured over to the swapchain, so the player still sees it
+
+func (capture *Capture) Blit(output *wgpu.TextureView) {
+	frame := capture.state.frame
+	pipeline := capture.state.post.pipelines["blit"]
+
+	frame.SetTargets(output, nil)
+
+	frame.Pass("capture blit", wgpu.LoadOp_Clear, wgpu.LoadOp_Clear, func(render_pass *wgpu.RenderPassEncoder) {
+		pipeline.Set(render_pass, capture.bind_group)
+		render_pass.Draw(3, 1, 0, 0)
+	}).Reads(capture.target.view)
+}
+
+// start copying the frame which was just captured back, once the frame has been executed
+
+func (capture *Capture) Read() {
+	state := capture.state
+	capture.requested = false
+
+	var path string
+
+	if capture.Recording() {
+		capture.record_frame++
+		path = filepath.Join(capture.dir, fmt.Sprintf("frame_%06d.png", capture.record_frame))
+	} else {
+		path = filepath.Join(capture.dir, "screenshot_"+time.Now().Format("2006-01-02_15-04-05.000")+".png")
+	}
+
+	readback, err := NewReadback(state, capture.target.texture, state.config.Format, capture.target_width, capture.target_height)
+	if err != nil {
+		log.Printf("Can't capture frame: %v\n", err)
+		return
+	}
+
+	capture.pending = append(capture.pending, &CaptureJob{readback: readback, path: path})
+
+	if len(capture.pending) > CAPTURE_MAX_PENDING {
+		state.device.Poll(true, nil)
+	}
+}
+
+// hand the frames which are done being copied back over to the writers, in the order they were captured
+
+func (capture *Capture) Poll() {
+	if len(capture.pending) == 0 {
+		return
+	}
+
+	capture.state.device.Poll(false, nil)
+
+	for len(capture.pending) > 0 && capture.pending[0].readback.Done() {
+		job := capture.pending[0]
+		capture.pending = capture.pending[1:]
+
+		img, err := job.readback.Image()
+		job.readback.Release()
+
+		if err != nil {
+			log.Printf("Can't capture frame: %v\n", err)
+			continue
+		}
+
+		if !capture.Recording() {
+			log.Println("Saving screenshot to", job.path)
+		}
+
+		capture.queueWrite(CaptureWrite{img: img, path: job.path})
+	}
+}
+
+// wait for every frame still being captured to be written
+
+func (capture *Capture) Release() {
+	if len(capture.pending) > 0 {
+		capture.state.device.Poll(true, nil)
+		capture.Poll()
+	}
+
+	capture.writes_lock.Lock()
+	capture.closed = true
+	capture.writes_lock.Unlock()
+
+	capture.writes_cond.Broadcast()
+	capture.writers.Wait()
+
+	capture.releaseTarget()
+}
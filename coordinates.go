@@ -40,6 +40,16 @@ func GetCoordinatesFromCsv(csvFile []byte) []*coordinates {
 	return coordinates
 }
 
+func GetCollidersFromCsv(csvFile []byte) []Collider {
+	var colliders []Collider
+
+	for _, coords := range GetCoordinatesFromCsv(csvFile) {
+		colliders = append(colliders, *NewCollider(coords.MeshName, coords.MostNegative, coords.MostPositive))
+	}
+
+	return colliders
+}
+
 // Function to convert string to float64
 func convertToFloat32(value string) float32 {
 	value = strings.TrimSpace(value) // Trim leading and trailing spaces
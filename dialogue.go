@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+
+	_ "embed"
+)
+
+//go:embed res/dialogues.csv
+var dialogues_csv []byte
+
+//go:embed res/dialogue_choices.csv
+var dialogue_choices_csv []byte
+
+var soundSystem *SoundSystem
+
+type DialogueChoice struct {
+	text      string
+	next      string
+	condition string
+}
+
+// speaker and text are IDs in the string table
+// a node with no text is a router: it shows nothing and immediately moves on to the first next node whose condition holds
+// otherwise, the conversation moves on once the node's line is done, or once a choice is made if it has any
+
+type DialogueNode struct {
+	id        string
+	speaker   string
+	text      string
+	next      []string
+	condition string
+	set       string
+	sound     string
+	wait_key  bool
+	choices   []*DialogueChoice
+}
+
+type Dialogue struct {
+	state *State
+	nodes map[string]*DialogueNode
+	queue *DialogueQueue
+
+	current *DialogueNode
+	choices []*DialogueChoice
+	pending []string
+
+	prev_keys map[glfw.Key]bool
+}
+
+var DIALOGUE_ADVANCE_KEYS = []glfw.Key{glfw.KeyEnter, glfw.KeyE}
+var DIALOGUE_CHOICE_KEYS = []glfw.Key{glfw.Key1, glfw.Key2, glfw.Key3, glfw.Key4, glfw.Key5, glfw.Key6, glfw.Key7, glfw.Key8, glfw.Key9}
+
+func splitLinks(links string) []string {
+	if links == "" {
+		return nil
+	}
+
+	return strings.Split(links, "|")
+}
+
+func NewDialogue(state *State, nodes_csv, choices_csv []byte) (*Dialogue, error) {
+	dialogue := &Dialogue{
+		state:     state,
+		nodes:     make(map[string]*DialogueNode),
+		queue:     NewDialogueQueue(state),
+		prev_keys: make(map[glfw.Key]bool),
+	}
+
+	// parse nodes
+
+	records, err := csv.NewReader(bytes.NewReader(nodes_csv)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; i < len(records); i++ {
+		record := records[i]
+
+		if len(record) != 8 {
+			return nil, fmt.Errorf("dialogue node on line %d has %d fields, expected 8", i+1, len(record))
+		}
+
+		if record[7] != "" && record[7] != "key" {
+			return nil, fmt.Errorf("dialogue node %q has unknown wait mode %q", record[0], record[7])
+		}
+
+		if _, ok := dialogue.nodes[record[0]]; ok {
+			return nil, fmt.Errorf("duplicate dialogue node %q", record[0])
+		}
+
+		dialogue.nodes[record[0]] = &DialogueNode{
+			id:        record[0],
+			speaker:   record[1],
+			text:      record[2],
+			next:      splitLinks(record[3]),
+			condition: record[4],
+			set:       record[5],
+			sound:     record[6],
+			wait_key:  record[7] == "key",
+		}
+	}
+
+	// parse choices
+
+	if records, err = csv.NewReader(bytes.NewReader(choices_csv)).ReadAll(); err != nil {
+		return nil, err
+	}
+
+	for i := 1; i < len(records); i++ {
+		record := records[i]
+
+		if len(record) != 4 {
+			return nil, fmt.Errorf("dialogue choice on line %d has %d fields, expected 4", i+1, len(record))
+		}
+
+		node, ok := dialogue.nodes[record[0]]
+		if !ok {
+			return nil, fmt.Errorf("dialogue choice on line %d refers to unknown node %q", i+1, record[0])
+		}
+
+		node.choices = append(node.choices, &DialogueChoice{
+			text:      record[1],
+			next:      record[2],
+			condition: record[3],
+		})
+	}
+
+	// make sure all links point somewhere and all strings exist
+
+	for _, node := range dialogue.nodes {
+		for _, next := range node.next {
+			if _, ok := dialogue.nodes[next]; !ok {
+				return nil, fmt.Errorf("dialogue node %q links to unknown node %q", node.id, next)
+			}
+		}
+
+		for _, id := range []string{node.speaker, node.text} {
+			if id != "" && !state.strings.Has(id) {
+				return nil, fmt.Errorf("dialogue node %q refers to unknown string %q", node.id, id)
+			}
+		}
+
+		for _, choice := range node.choices {
+			if _, ok := dialogue.nodes[choice.next]; !ok {
+				return nil, fmt.Errorf("dialogue choice in node %q links to unknown node %q", node.id, choice.next)
+			}
+
+			if !state.strings.Has(choice.text) {
+				return nil, fmt.Errorf("dialogue choice in node %q refers to unknown string %q", node.id, choice.text)
+			}
+		}
+	}
+
+	return dialogue, nil
+}
+
+// returns the first node whose condition holds, or nil if there are none
+
+func (dialogue *Dialogue) resolve(ids []string) *DialogueNode {
+	for _, id := range ids {
+		node, ok := dialogue.nodes[id]
+
+		if !ok {
+			log.Printf("Unknown dialogue node %q\n", id)
+			continue
+		}
+
+		if dialogue.state.flags.Check(node.condition) {
+			return node
+		}
+	}
+
+	return nil
+}
+
+func (dialogue *Dialogue) enter(node *DialogueNode) {
+	for node != nil && node.text == "" {
+		dialogue.state.flags.Apply(node.set)
+		node = dialogue.resolve(node.next)
+	}
+
+	dialogue.current = node
+	dialogue.choices = nil
+
+	if node == nil {
+		if len(dialogue.pending) > 0 {
+			id := dialogue.pending[0]
+			dialogue.pending = dialogue.pending[1:]
+			dialogue.Start(id)
+		}
+
+		return
+	}
+
+	dialogue.state.flags.Apply(node.set)
+
+	table := dialogue.state.strings
+	line := table.Get(node.text)
+
+	if node.speaker != "" {
+		line = fmt.Sprintf("%s : %s", table.Get(node.speaker), line)
+	}
+
+	var choices []string
+
+	for _, choice := range node.choices {
+		if dialogue.state.flags.Check(choice.condition) {
+			dialogue.choices = append(dialogue.choices, choice)
+			choices = append(choices, table.Get(choice.text))
+		}
+	}
+
+	dialogue.queue.Push(&DialogueLine{
+		id:       node.id,
+		text:     line,
+		choices:  choices,
+		sound:    node.sound,
+		wait_key: node.wait_key || len(choices) > 0,
+		done: func() {
+			// nodes with choices are moved on from by Choose
+
+			if dialogue.current == node && len(dialogue.choices) == 0 {
+				dialogue.enter(dialogue.resolve(node.next))
+			}
+		},
+	})
+}
+
+// start a conversation, or queue it up if one is already running
+// id may be a '|'-separated list of nodes, in which case the first whose condition holds is picked
+
+func (dialogue *Dialogue) Start(id string) {
+	if dialogue.Active() {
+		dialogue.pending = append(dialogue.pending, id)
+		return
+	}
+
+	dialogue.enter(dialogue.resolve(splitLinks(id)))
+}
+
+// drop the current conversation and any pending ones
+
+func (dialogue *Dialogue) Stop() {
+	dialogue.current = nil
+	dialogue.choices = nil
+	dialogue.pending = nil
+	dialogue.queue.Clear()
+}
+
+func (dialogue *Dialogue) Active() bool {
+	return dialogue.current != nil
+}
+
+// skip to the next line, unless the player has a choice to make
+
+func (dialogue *Dialogue) Advance() {
+	if dialogue.current == nil || len(dialogue.choices) > 0 {
+		return
+	}
+
+	dialogue.queue.Skip()
+}
+
+func (dialogue *Dialogue) Choose(i int) {
+	if i < 0 || i >= len(dialogue.choices) {
+		return
+	}
+
+	next := dialogue.choices[i].next
+
+	dialogue.current = nil
+	dialogue.choices = nil
+	dialogue.queue.Skip()
+
+	dialogue.enter(dialogue.resolve([]string{next}))
+}
+
+func (dialogue *Dialogue) pressed(key glfw.Key) bool {
+	down := dialogue.state.win.GetKey(key) == glfw.Press
+	was_down := dialogue.prev_keys[key]
+	dialogue.prev_keys[key] = down
+
+	return down && !was_down
+}
+
+func (dialogue *Dialogue) Update() {
+	for _, key := range DIALOGUE_ADVANCE_KEYS {
+		if dialogue.pressed(key) {
+			dialogue.Advance()
+		}
+	}
+
+	for i, key := range DIALOGUE_CHOICE_KEYS {
+		if dialogue.pressed(key) {
+			dialogue.Choose(i)
+		}
+	}
+
+	dialogue.queue.Update()
+}
+
+func playSound(name string, state *State) {
+	sound, ok := state.decodeded_sounds[name]
+
+	if !ok {
+		log.Printf("Unknown sound %q\n", name)
+		return
+	}
+
+	if soundSystem == nil {
+		soundSystem = NewSoundSystem()
+		soundSystem.InitSpeaker(sound.format)
+	}
+
+	if err := soundSystem.PlaySound(name, state); err != nil {
+		panic(err)
+	}
+}
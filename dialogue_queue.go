@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"unicode/utf8"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// lines without a voice line are shown for as long as it takes to read them
+
+const READING_SPEED = 15 // characters per second
+const MIN_LINE_DURATION = 1.5
+const LINE_PAUSE = .3
+
+// where lines are drawn, in clip space, with their choices listed below them
+// a line of text is 13 of the 100 pixels of the texture it's drawn to, so choices are spaced a bit more than that apart
+
+const LINE_X = 0
+const LINE_Y = 0
+const LINE_SCALE = 1
+const CHOICE_SPACING = .15
+
+type DialogueLine struct {
+	id       string
+	text     string
+	choices  []string
+	sound    string
+	wait_key bool
+	done     func()
+}
+
+// plays dialogue lines one after the other, so they don't cut each other off
+// once a line is done, its done callback is called and a "dialogue_done:<id>" event is emitted
+
+type DialogueQueue struct {
+	state *State
+	lines []*DialogueLine
+
+	current   *DialogueLine
+	remaining float32
+
+	texts []*Text // the current line's, then its choices'
+}
+
+func NewDialogueQueue(state *State) *DialogueQueue {
+	return &DialogueQueue{state: state}
+}
+
+func (queue *DialogueQueue) Push(line *DialogueLine) {
+	queue.lines = append(queue.lines, line)
+}
+
+func (queue *DialogueQueue) Busy() bool {
+	return queue.current != nil || len(queue.lines) > 0
+}
+
+func (queue *DialogueQueue) LineDuration(line *DialogueLine) float32 {
+	if sound, ok := queue.state.decodeded_sounds[line.sound]; ok && sound.streamer != nil {
+		return sound.Duration() + LINE_PAUSE
+	}
+
+	duration := float32(utf8.RuneCountInString(line.text)) / READING_SPEED
+
+	if duration < MIN_LINE_DURATION {
+		duration = MIN_LINE_DURATION
+	}
+
+	return duration + LINE_PAUSE
+}
+
+func (queue *DialogueQueue) begin(line *DialogueLine) {
+	queue.current = line
+	queue.remaining = queue.LineDuration(line)
+
+	queue.show(line)
+
+	if line.sound != "" {
+		playSound(line.sound, queue.state)
+	}
+}
+
+func (queue *DialogueQueue) finish() {
+	line := queue.current
+	queue.current = nil
+
+	// cut the voice line off if it's being skipped
+
+	if queue.remaining > LINE_PAUSE && line.sound != "" && soundSystem != nil {
+		soundSystem.Stop()
+	}
+
+	if len(queue.lines) == 0 {
+		queue.show(nil)
+	}
+
+	if line.done != nil {
+		line.done()
+	}
+
+	queue.state.events.Emit("dialogue_done:" + line.id)
+}
+
+// finish the current line early, whether or not it's waiting for a keypress
+
+func (queue *DialogueQueue) Skip() {
+	if queue.current != nil {
+		queue.finish()
+	}
+}
+
+// drop all lines without finishing them
+
+func (queue *DialogueQueue) Clear() {
+	if queue.current != nil && queue.current.sound != "" && soundSystem != nil {
+		soundSystem.Stop()
+	}
+
+	queue.current = nil
+	queue.lines = nil
+	queue.show(nil)
+}
+
+func (queue *DialogueQueue) Update() {
+	if queue.current == nil {
+		if len(queue.lines) == 0 {
+			return
+		}
+
+		line := queue.lines[0]
+		queue.lines = queue.lines[1:]
+		queue.begin(line)
+	}
+
+	queue.remaining -= queue.state.dt
+
+	if queue.remaining <= 0 && !queue.current.wait_key {
+		queue.finish()
+	}
+}
+
+// choices are prefixed with the key choosing them, i.e. their number
+
+func (queue *DialogueQueue) show(line *DialogueLine) {
+	for _, text := range queue.texts {
+		text.Release()
+	}
+
+	queue.texts = nil
+
+	if line == nil || line.text == "" {
+		return
+	}
+
+	log.Println(line.text)
+
+	contents := []string{line.text}
+
+	for i, choice := range line.choices {
+		hint := fmt.Sprintf("%d. %s", i+1, choice)
+		log.Printf("\t%s\n", hint)
+		contents = append(contents, hint)
+	}
+
+	for i, content := range contents {
+		text, err := NewText(queue.state, content, LINE_X, LINE_Y-float32(i)*CHOICE_SPACING, LINE_SCALE, LINE_SCALE)
+		if err != nil {
+			panic(err)
+		}
+
+		queue.texts = append(queue.texts, text)
+	}
+}
+
+// whether there's any text to draw, so the text pass can be left out when there isn't
+
+func (queue *DialogueQueue) Showing() bool {
+	return len(queue.texts) > 0
+}
+
+func (queue *DialogueQueue) Draw(render_pass *wgpu.RenderPassEncoder) {
+	for _, text := range queue.texts {
+		text.Draw(render_pass)
+	}
+}
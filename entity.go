@@ -14,6 +14,15 @@ type Entity struct {
 	height          float32
 	collider        *Collider
 	grounded        bool
+
+	// rotation from the entity's own space to the world's, which changes when going through portals
+	// gravity pulls along the entity's own -Y, so a portal can turn it sideways or upside down
+
+	orientation *Mat
+
+	// portal the entity came out of during its last update, if any
+
+	traversed *Portal
 }
 
 type PotentialCollision struct {
@@ -23,7 +32,7 @@ type PotentialCollision struct {
 	collider  *Collider
 }
 
-var GRAVITY_ACCEL = []float32{0, -9.81, 0}
+var GRAVITY_ACCEL = [3]float32{0, -9.81, 0}
 var FRICTION = []float32{20, 20, 20}
 var DRAG_JUMP = []float32{1.8, 0, 1.8}
 var DRAG_FALL = []float32{1.8, .4, 1.8}
@@ -40,6 +49,7 @@ func NewEntity(state *State, position [3]float32, rotation [2]float32, width flo
 		height:      height,
 		collider:    &Collider{},
 		grounded:    false,
+		orientation: NewMat(),
 	}
 
 	return entity
@@ -54,19 +64,83 @@ func NewPotentialCollision(name string, entryTime float32, normal [3]float32, co
 	}
 }
 
-func (entity *Entity) Update(models []*Model) {
+func (entity *Entity) Up() [3]float32 {
+	return entity.orientation.TransformVector([3]float32{0, 1, 0})
+}
+
+// colliders are axis-aligned, so physics only know about gravity along one of the world's axes
+// this returns that axis and whether up is along it or against it
+
+func (entity *Entity) upAxis() (int, float32) {
+	up := entity.Up()
+	axis := 0
+
+	for i := 1; i < 3; i++ {
+		if math.Abs(float64(up[i])) > math.Abs(float64(up[axis])) {
+			axis = i
+		}
+	}
+
+	if up[axis] < 0 {
+		return axis, -1
+	}
+
+	return axis, 1
+}
+
+func (entity *Entity) Centre() [3]float32 {
+	up := entity.Up()
+	centre := entity.pos
+
+	for i := range centre {
+		centre[i] += up[i] * entity.height / 2
+	}
+
+	return centre
+}
+
+// move the entity by a rigid transform, e.g. the one taking it through a portal
+
+func (entity *Entity) Transform(mat *Mat) {
+	rotation := mat.Copy()
+	rotation.Data[3] = [4]float32{0, 0, 0, 1}
+
+	entity.pos = mat.TransformPoint(entity.pos)
+	entity.vel = mat.TransformVector(entity.vel)
+	entity.acc = mat.TransformVector(entity.acc)
+	entity.orientation = rotation.Multiply(entity.orientation)
+}
+
+func (entity *Entity) Update(nodes []*SceneNode) {
 	dt := entity.state.dt
+	axis, sign := entity.upAxis()
+	prev_centre := entity.Centre()
 
 	// compute friction/drag
+	// the coefficients are given for a Y-up entity, so the vertical one goes to whichever axis is up
 
-	fx, fy, fz := DRAG_FALL[0], DRAG_FALL[1], DRAG_FALL[2]
+	coeffs := DRAG_FALL
 
 	if entity.grounded {
-		fx, fy, fz = FRICTION[0], FRICTION[1], FRICTION[2]
-	} else if entity.vel[1] > 0 {
-		fx, fy, fz = DRAG_JUMP[0], DRAG_JUMP[1], DRAG_JUMP[2]
+		coeffs = FRICTION
+	} else if entity.vel[axis]*sign > 0 {
+		coeffs = DRAG_JUMP
 	}
 
+	var f [3]float32
+	horizontal := 0
+
+	for i := range f {
+		if i == axis {
+			f[i] = coeffs[1]
+		} else {
+			f[i] = coeffs[horizontal]
+			horizontal += 2
+		}
+	}
+
+	fx, fy, fz := f[0], f[1], f[2]
+
 	// input acceleration + friction compensation
 
 	entity.vel[0] += entity.acc[0] * fx * dt
@@ -77,10 +151,18 @@ func (entity *Entity) Update(models []*Model) {
 
 	// update collider
 
-	x, y, z := entity.pos[0], entity.pos[1], entity.pos[2]
+	for i := 0; i < 3; i++ {
+		entity.collider.position1[i] = entity.pos[i] - entity.width/2
+		entity.collider.position2[i] = entity.pos[i] + entity.width/2
+	}
 
-	entity.collider.position1 = [3]float32{x - entity.width/2, y, z - entity.width/2}
-	entity.collider.position2 = [3]float32{x + entity.width/2, y + entity.height, z + entity.width/2}
+	if sign > 0 {
+		entity.collider.position1[axis] = entity.pos[axis]
+		entity.collider.position2[axis] = entity.pos[axis] + entity.height
+	} else {
+		entity.collider.position1[axis] = entity.pos[axis] - entity.height
+		entity.collider.position2[axis] = entity.pos[axis]
+	}
 
 	// collide with colliders
 
@@ -94,14 +176,16 @@ func (entity *Entity) Update(models []*Model) {
 
 		candidates := []PotentialCollision{}
 
-		for _, model := range models {
-			for j := 0; j < len(model.colliders); j++ {
-				if model.colliders[j].ignore {
+		for _, node := range nodes {
+			world_colliders := node.WorldColliders()
+
+			for j := 0; j < len(world_colliders); j++ {
+				if node.colliders[j].ignore {
 					continue
 				}
-				name, collided, normals := entity.collider.Collide(&model.colliders[j], vx, vy, vz)
+				name, collided, normals := entity.collider.Collide(&world_colliders[j], vx, vy, vz)
 				if collided < 1 {
-					potentialCollision := NewPotentialCollision(name, collided, normals, &model.colliders[j])
+					potentialCollision := NewPotentialCollision(name, collided, normals, &node.colliders[j])
 					candidates = append(candidates, *potentialCollision)
 				}
 			}
@@ -137,34 +221,31 @@ func (entity *Entity) Update(models []*Model) {
 		if earliest_collision.normal[1] != 0 {
 			entity.pos[1] += vy * earliest_time
 			entity.vel[1] = 0
-
-			if earliest_collision.normal[1] > 0 {
-				entity.grounded = true
-			}
 		}
 
 		if earliest_collision.normal[2] != 0 {
 			entity.pos[2] += vz * earliest_time
 			entity.vel[2] = 0
 		}
+
+		if earliest_collision.normal[axis]*sign > 0 {
+			entity.grounded = true
+		}
 	}
 
-	// collide with heightmaps
+	// collide with heightmaps, which only ever push up along Y
 
-	for _, model := range models {
-		if model.heightmap == nil {
+	for _, node := range nodes {
+		if node.model == nil || node.model.heightmap == nil || axis != 1 || sign < 0 {
 			continue
 		}
 
-		px, py, pz := entity.pos[0], entity.pos[1], entity.pos[2]
+		model := node.model
+		local := node.World().Copy().Inverse().TransformPoint(entity.pos)
 
-		px -= model.collider_off_x
-		py -= model.collider_off_y
-		pz -= model.collider_off_z
-
-		px /= M_TO_AYLIN
-		py /= M_TO_AYLIN
-		pz /= M_TO_AYLIN
+		px := local[0] / M_TO_AYLIN
+		py := local[1] / M_TO_AYLIN
+		pz := local[2] / M_TO_AYLIN
 
 		x := int(float32(model.heightmap.res) * (px - model.heightmap.neg_x) / (model.heightmap.pos_x - model.heightmap.neg_x))
 		y := int(float32(model.heightmap.res) * (pz - model.heightmap.neg_z) / (model.heightmap.pos_z - model.heightmap.neg_z))
@@ -176,7 +257,7 @@ func (entity *Entity) Update(models []*Model) {
 		height := model.heightmap.heightmap[x][y]
 
 		if py < height {
-			entity.pos[1] = height*M_TO_AYLIN + model.collider_off_y
+			entity.pos = node.World().TransformPoint([3]float32{local[0], height * M_TO_AYLIN, local[2]})
 			entity.vel[1] = 0
 			entity.grounded = true
 		}
@@ -190,7 +271,11 @@ func (entity *Entity) Update(models []*Model) {
 
 	// apply gravity
 
-	entity.vel[1] += GRAVITY_ACCEL[1] * dt
+	gravity := entity.orientation.TransformVector(GRAVITY_ACCEL)
+
+	entity.vel[0] += gravity[0] * dt
+	entity.vel[1] += gravity[1] * dt
+	entity.vel[2] += gravity[2] * dt
 
 	// friction
 
@@ -211,48 +296,43 @@ func (entity *Entity) Update(models []*Model) {
 	entity.vel[0] += entity.trigger_impulse[0]
 	entity.vel[1] += entity.trigger_impulse[1]
 	entity.vel[2] += entity.trigger_impulse[2]
+
+	// go through portals once our centre crosses one
+
+	entity.traversed = nil
+
+	if portal, destination := entity.state.portals.Crossing(prev_centre, entity.Centre()); portal != nil {
+		entity.Transform(PortalTransform(portal.node, destination.node))
+		entity.traversed = destination
+	}
 }
 
 func (entity *Entity) Jump() {
 	if entity.grounded {
-		entity.vel[1] = float32(math.Sqrt(-2 * float64(GRAVITY_ACCEL[1]*entity.jump_height)))
+		axis, sign := entity.upAxis()
+		entity.vel[axis] = sign * float32(math.Sqrt(-2*float64(GRAVITY_ACCEL[1]*entity.jump_height)))
 	}
 }
 
-var apat_already_spoken = false
 var not_touching_apat = true
 
 func (entity *Entity) prossesTrigger(trigger string, state *State, collider *Collider) {
-	println("trig", trigger)
-	if trigger == "Col_Sink" && !state.alexis_room.sink_activated {
-		displayDialogue(getDialogues(), "intro2", state)
-		state.alexis_room.sink_activated = true
-	} else if trigger == "Col_Door" && state.alexis_room.sink_activated {
-		displayDialogue(getDialogues(), "outro4", state)
+	if trigger == "Col_Sink" && !state.flags.Check("sink_activated") {
+		state.dialogue.Start("intro2")
+		state.flags.Apply("sink_activated")
+	} else if trigger == "Col_Door" && state.flags.Check("sink_activated") {
+		state.dialogue.Start("outro4")
 		// TODO : i input
-		state.alexis_room.door_opened = true
+		state.flags.Apply("door_opened")
 		collider.ignore = true
 		entity.trigger_impulse[0] = -30
 	} else if trigger == "Col_Ukulele" {
-		displayDialogue(getDialogues(), "ukulele5", state)
-		state.alexis_room.door_opened = true
-		state.apat.ukulele_picked_up = true
+		state.dialogue.Start("ukulele4")
+		state.flags.Apply("door_opened ukulele_picked_up")
 		collider.ignore = true
-		state.apat.portal_lit = true
-	} else if trigger == "Col_Purple" && state.apat.ukulele_picked_up {
-		displayDialogue(getDialogues(), "outro3", state)
-		state.obama_room.should_draw = true
 	} else if trigger == "Col_Apat" {
-		println("apat")
 		if not_touching_apat {
-			if apat_already_spoken {
-				println("bonus")
-				// displayDialogue(getDialogues(), "bonus", state)
-			} else {
-				displayDialogue(getDialogues(), "ukulele2", state)
-				apat_already_spoken = true
-			}
-
+			state.dialogue.Start("apatien")
 			not_touching_apat = false
 		}
 	} else {
@@ -0,0 +1,21 @@
+package main
+
+// named events which scripts can hook into, e.g. "dialogue_done:outro4" when a dialogue line has finished
+
+type Events struct {
+	handlers map[string][]func()
+}
+
+func NewEvents() *Events {
+	return &Events{handlers: make(map[string][]func())}
+}
+
+func (events *Events) On(name string, handler func()) {
+	events.handlers[name] = append(events.handlers[name], handler)
+}
+
+func (events *Events) Emit(name string) {
+	for _, handler := range events.handlers[name] {
+		handler()
+	}
+}
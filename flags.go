@@ -0,0 +1,32 @@
+package main
+
+import "strings"
+
+// story flags, set by dialogues and triggers and checked by conditions
+// conditions and changes are whitespace-separated lists of flag names, optionally prefixed by '!'
+
+type Flags map[string]bool
+
+func (flags Flags) Check(condition string) bool {
+	for _, term := range strings.Fields(condition) {
+		if strings.HasPrefix(term, "!") {
+			if flags[term[1:]] {
+				return false
+			}
+		} else if !flags[term] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (flags Flags) Apply(changes string) {
+	for _, term := range strings.Fields(changes) {
+		if strings.HasPrefix(term, "!") {
+			delete(flags, term[1:])
+		} else {
+			flags[term] = true
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"log"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// a frame is declared as a list of passes, which are only encoded at the end of the frame, all into the same encoder
+// since nothing is submitted before then, uniforms can't be written with queue.WriteBuffer in between passes
+// instead they're uploaded to a staging buffer once, and copied into place right before the pass which needs them
+
+type FrameUpload struct {
+	dst    *wgpu.Buffer
+	offset uint64 // in the staging buffer
+	size   uint64
+}
+
+type FramePass struct {
+	name string
+
+	colour      *wgpu.TextureView // no colour attachment if nil, e.g. for shadow maps
+	depth       *wgpu.TextureView // no depth attachment if nil
+	colour_load wgpu.LoadOp
+	depth_load  wgpu.LoadOp
+
+	reads   []*wgpu.TextureView
+	uploads []FrameUpload
+	draw    func(render_pass *wgpu.RenderPassEncoder)
+
+	// filled in when the graph is sorted
+
+	deps []*FramePass
+}
+
+// declare that a pass samples from a texture another pass renders to, so it's run after it
+
+func (pass *FramePass) Reads(views ...*wgpu.TextureView) *FramePass {
+	pass.reads = append(pass.reads, views...)
+	return pass
+}
+
+// how many draws were declared in a frame, and how many of them were culled
+
+type FrameStats struct {
+	draws  int
+	culled int
+}
+
+type FrameGraph struct {
+	state *State
+
+	stats      FrameStats
+	last_stats FrameStats
+
+	// attachments of passes added from now on
+
+	colour *wgpu.TextureView
+	depth  *wgpu.TextureView
+
+	passes  []*FramePass
+	pending []FrameUpload
+
+	staging          []byte
+	staging_buf      *wgpu.Buffer
+	staging_capacity uint64
+}
+
+func NewFrameGraph(state *State) *FrameGraph {
+	return &FrameGraph{state: state}
+}
+
+func (graph *FrameGraph) SetTargets(colour, depth *wgpu.TextureView) {
+	graph.colour = colour
+	graph.depth = depth
+}
+
+func (graph *FrameGraph) Targets() (*wgpu.TextureView, *wgpu.TextureView) {
+	return graph.colour, graph.depth
+}
+
+// copy data into a buffer before the next pass is run
+
+func (graph *FrameGraph) Upload(dst *wgpu.Buffer, data []byte) {
+	offset := uint64(len(graph.staging))
+	graph.staging = append(graph.staging, data...)
+
+	// copies must be 4-byte aligned
+
+	for len(graph.staging)%4 != 0 {
+		graph.staging = append(graph.staging, 0)
+	}
+
+	graph.pending = append(graph.pending, FrameUpload{dst: dst, offset: offset, size: uint64(len(data))})
+}
+
+// add a pass drawing to the current targets
+// draw may be nil, e.g. for passes which only clear their attachments
+
+func (graph *FrameGraph) Pass(name string, colour_load, depth_load wgpu.LoadOp, draw func(render_pass *wgpu.RenderPassEncoder)) *FramePass {
+	pass := &FramePass{
+		name:        name,
+		colour:      graph.colour,
+		depth:       graph.depth,
+		colour_load: colour_load,
+		depth_load:  depth_load,
+		uploads:     graph.pending,
+		draw:        draw,
+	}
+
+	graph.pending = nil
+	graph.passes = append(graph.passes, pass)
+
+	return pass
+}
+
+// a pass depends on the last pass to write what it reads or writes, and the passes which read what it overwrites
+// this keeps declaration order for passes sharing attachments, so declaration order is always a valid order
+// sorting still moves a pass after the passes it reads from if it was declared before them
+
+func (graph *FrameGraph) sort() []*FramePass {
+	last_writer := make(map[*wgpu.TextureView]*FramePass)
+	readers := make(map[*wgpu.TextureView][]*FramePass)
+
+	for _, pass := range graph.passes {
+		pass.deps = nil
+	}
+
+	// a first sweep to find writers of textures read before they are written
+
+	writers := make(map[*wgpu.TextureView][]*FramePass)
+
+	for _, pass := range graph.passes {
+		for _, view := range []*wgpu.TextureView{pass.colour, pass.depth} {
+			if view != nil {
+				writers[view] = append(writers[view], pass)
+			}
+		}
+	}
+
+	for _, pass := range graph.passes {
+		for _, view := range pass.reads {
+			if writer, ok := last_writer[view]; ok {
+				pass.deps = append(pass.deps, writer)
+			} else if later := writers[view]; len(later) > 0 {
+				pass.deps = append(pass.deps, later[len(later)-1])
+			}
+
+			readers[view] = append(readers[view], pass)
+		}
+
+		for _, view := range []*wgpu.TextureView{pass.colour, pass.depth} {
+			if view == nil {
+				continue
+			}
+
+			if writer, ok := last_writer[view]; ok {
+				pass.deps = append(pass.deps, writer)
+			}
+
+			for _, reader := range readers[view] {
+				if reader != pass {
+					pass.deps = append(pass.deps, reader)
+				}
+			}
+
+			last_writer[view] = pass
+			readers[view] = nil
+		}
+	}
+
+	// Kahn's algorithm, taking passes in declaration order whenever there's a choice
+
+	remaining := make(map[*FramePass]int)
+	dependents := make(map[*FramePass][]*FramePass)
+
+	for _, pass := range graph.passes {
+		for _, dep := range pass.deps {
+			if dep == pass {
+				continue
+			}
+
+			remaining[pass]++
+			dependents[dep] = append(dependents[dep], pass)
+		}
+	}
+
+	done := make(map[*FramePass]bool)
+	var order []*FramePass
+
+	for len(order) < len(graph.passes) {
+		progress := false
+
+		for _, pass := range graph.passes {
+			if done[pass] || remaining[pass] > 0 {
+				continue
+			}
+
+			done[pass] = true
+			order = append(order, pass)
+			progress = true
+
+			for _, dependent := range dependents[pass] {
+				remaining[dependent]--
+			}
+
+			break
+		}
+
+		if !progress {
+			log.Println("Frame graph has a cycle, running passes in the order they were declared")
+			return graph.passes
+		}
+	}
+
+	return order
+}
+
+func (graph *FrameGraph) uploadStaging() {
+	size := uint64(len(graph.staging))
+
+	if size == 0 {
+		return
+	}
+
+	if size > graph.staging_capacity {
+		if graph.staging_buf != nil {
+			graph.staging_buf.Release()
+		}
+
+		capacity := graph.staging_capacity
+
+		if capacity == 0 {
+			capacity = 4096
+		}
+
+		for capacity < size {
+			capacity *= 2
+		}
+
+		var err error
+
+		if graph.staging_buf, err = graph.state.device.CreateBuffer(&wgpu.BufferDescriptor{
+			Label: "Frame staging buffer",
+			Size:  capacity,
+			Usage: wgpu.BufferUsage_CopySrc | wgpu.BufferUsage_CopyDst,
+		}); err != nil {
+			log.Fatal(err)
+		}
+
+		graph.staging_capacity = capacity
+	}
+
+	graph.state.queue.WriteBuffer(graph.staging_buf, 0, graph.staging)
+}
+
+// encode every pass declared this frame and submit them all at once
+
+func (graph *FrameGraph) Execute() {
+	state := graph.state
+
+	if len(graph.pending) > 0 {
+		log.Println("Frame graph has uploads which no pass uses")
+	}
+
+	graph.uploadStaging()
+	state.uniforms.Flush()
+
+	encoder, err := state.device.CreateCommandEncoder(&wgpu.CommandEncoderDescriptor{
+		Label: "Frame command encoder",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer encoder.Release()
+
+	for _, pass := range graph.sort() {
+		for _, upload := range pass.uploads {
+			if err := encoder.CopyBufferToBuffer(graph.staging_buf, upload.offset, upload.dst, 0, upload.size); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		var depth_attachment *wgpu.RenderPassDepthStencilAttachment
+
+		if pass.depth != nil {
+			depth_attachment = &wgpu.RenderPassDepthStencilAttachment{
+				View:              pass.depth,
+				DepthClearValue:   1,
+				DepthLoadOp:       pass.depth_load,
+				DepthStoreOp:      wgpu.StoreOp_Store,
+				DepthReadOnly:     false,
+				StencilClearValue: 0,
+				StencilLoadOp:     wgpu.LoadOp_Load,
+				StencilStoreOp:    wgpu.StoreOp_Store,
+				StencilReadOnly:   true,
+			}
+		}
+
+		var colour_attachments []wgpu.RenderPassColorAttachment
+
+		if pass.colour != nil {
+			colour_attachments = []wgpu.RenderPassColorAttachment{
+				{
+					View:       pass.colour,
+					LoadOp:     pass.colour_load,
+					StoreOp:    wgpu.StoreOp_Store,
+					ClearValue: wgpu.Color{R: 1, G: 0, B: 0, A: 1},
+				},
+			}
+		}
+
+		render_pass := encoder.BeginRenderPass(&wgpu.RenderPassDescriptor{
+			Label:                  pass.name,
+			ColorAttachments:       colour_attachments,
+			DepthStencilAttachment: depth_attachment,
+		})
+
+		if pass.draw != nil {
+			pass.draw(render_pass)
+		}
+
+		render_pass.End()
+		render_pass.Release()
+	}
+
+	cmd_buf, err := encoder.Finish(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cmd_buf.Release()
+
+	state.queue.Submit(cmd_buf)
+
+	graph.last_stats = graph.stats
+	graph.stats = FrameStats{}
+
+	graph.passes = nil
+	graph.pending = nil
+	graph.staging = graph.staging[:0]
+}
+
+// stats of the last frame which was executed
+
+func (graph *FrameGraph) Stats() FrameStats {
+	return graph.last_stats
+}
+
+func (graph *FrameGraph) Release() {
+	if graph.staging_buf != nil {
+		graph.staging_buf.Release()
+	}
+}
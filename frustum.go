@@ -0,0 +1,142 @@
+package main
+
+import "math"
+
+// planes of a camera's view volume, pointing inwards, as (a, b, c, d) with ax + by + cz + d >= 0 inside
+// they're extracted straight from a view/projection matrix, so with an oblique projection the near plane is the portal's plane
+
+type Frustum struct {
+	planes [6][4]float32
+}
+
+func NewFrustum(vp *Mat) *Frustum {
+	frustum := &Frustum{}
+
+	// rows of the matrix, which is stored by column
+
+	var rows [4][4]float32
+
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			rows[i][j] = vp.Data[j][i]
+		}
+	}
+
+	// left, right, bottom, top, with -w <= x, y <= w inside
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 4; j++ {
+			frustum.planes[i*2][j] = rows[3][j] + rows[i][j]
+			frustum.planes[i*2+1][j] = rows[3][j] - rows[i][j]
+		}
+	}
+
+	// near and far, with 0 <= z <= w inside, as WebGPU clips depth to [0, 1] (and not to [-1, 1] like OpenGL)
+	// this is also what ObliqueProjection's near plane is made for
+
+	for j := 0; j < 4; j++ {
+		frustum.planes[4][j] = rows[2][j]
+		frustum.planes[5][j] = rows[3][j] - rows[2][j]
+	}
+
+	for i := range frustum.planes {
+		plane := &frustum.planes[i]
+		length := float32(math.Sqrt(float64(plane[0]*plane[0] + plane[1]*plane[1] + plane[2]*plane[2])))
+
+		if length == 0 {
+			continue
+		}
+
+		for j := range plane {
+			plane[j] /= length
+		}
+	}
+
+	return frustum
+}
+
+func (frustum *Frustum) SphereVisible(centre [3]float32, radius float32) bool {
+	for _, plane := range frustum.planes {
+		if plane[0]*centre[0]+plane[1]*centre[1]+plane[2]*centre[2]+plane[3] < -radius {
+			return false
+		}
+	}
+
+	return true
+}
+
+// a box is only outside if its corner furthest along a plane's normal is still behind it
+
+func (frustum *Frustum) BoxVisible(min, max [3]float32) bool {
+	for _, plane := range frustum.planes {
+		corner := min
+
+		for k := 0; k < 3; k++ {
+			if plane[k] > 0 {
+				corner[k] = max[k]
+			}
+		}
+
+		if plane[0]*corner[0]+plane[1]*corner[1]+plane[2]*corner[2]+plane[3] < 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// test a model's bounds once they're transformed by a model matrix
+// the sphere test is cheap, so it's done first, and the box test only for what it can't rule out
+
+func (frustum *Frustum) ModelVisible(model *Model, transform *Mat) bool {
+	// the radius grows by the largest scale of the transform
+
+	scale := float32(0)
+
+	for i := 0; i < 3; i++ {
+		column := transform.Data[i]
+		length := float32(math.Sqrt(float64(column[0]*column[0] + column[1]*column[1] + column[2]*column[2])))
+
+		if length > scale {
+			scale = length
+		}
+	}
+
+	if !frustum.SphereVisible(transform.TransformPoint(model.bounds_centre), model.bounds_radius*scale) {
+		return false
+	}
+
+	// axis-aligned box around the transformed box
+
+	var min, max [3]float32
+
+	for j := 0; j < 8; j++ {
+		corner := model.bounds_min
+
+		for k := 0; k < 3; k++ {
+			if j&(1<<k) != 0 {
+				corner[k] = model.bounds_max[k]
+			}
+		}
+
+		corner = transform.TransformPoint(corner)
+
+		if j == 0 {
+			min = corner
+			max = corner
+			continue
+		}
+
+		for k := 0; k < 3; k++ {
+			if corner[k] < min[k] {
+				min[k] = corner[k]
+			}
+
+			if corner[k] > max[k] {
+				max[k] = corner[k]
+			}
+		}
+	}
+
+	return frustum.BoxVisible(min, max)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// golden-image tests compare what's rendered headlessly with reference PNGs in goldens/
+// these aren't in the repo until someone renders them on their machine and checks them by eye (tools/goldens.sh --update)
+// GPUs and software rasterisers don't all round, filter and dither exactly alike, so small differences are tolerated
+
+type GoldenTolerance struct {
+	threshold uint8   // largest difference in any channel for a pixel to still count as the same
+	fraction  float64 // of the pixels which may differ by more than that
+}
+
+var DEFAULT_GOLDEN_TOLERANCE = GoldenTolerance{threshold: 8, fraction: .001}
+
+// tolerances are given as threshold,fraction, e.g. "8,.001"
+
+func ParseGoldenTolerance(tolerance string) (GoldenTolerance, error) {
+	fields := strings.Split(tolerance, ",")
+
+	if len(fields) != 2 {
+		return GoldenTolerance{}, fmt.Errorf("golden tolerance %q should be threshold,fraction", tolerance)
+	}
+
+	threshold, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 8)
+	if err != nil {
+		return GoldenTolerance{}, fmt.Errorf("golden tolerance %q: %v", tolerance, err)
+	}
+
+	fraction, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+	if err != nil {
+		return GoldenTolerance{}, fmt.Errorf("golden tolerance %q: %v", tolerance, err)
+	}
+
+	if fraction < 0 || fraction > 1 {
+		return GoldenTolerance{}, fmt.Errorf("golden tolerance %q: fraction of pixels should be between 0 and 1", tolerance)
+	}
+
+	return GoldenTolerance{threshold: uint8(threshold), fraction: fraction}, nil
+}
+
+type GoldenDiff struct {
+	differing int // pixels differing by more than the threshold
+	total     int
+	worst     uint8 // largest difference in any channel of any pixel
+
+	// what was rendered, faded, with the pixels differing by more than the threshold in red
+
+	img *image.RGBA
+}
+
+func (diff *GoldenDiff) Within(tolerance GoldenTolerance) bool {
+	return float64(diff.differing) <= tolerance.fraction*float64(diff.total)
+}
+
+// images of different sizes can't be compared, and are always an error
+
+func CompareGolden(got, expected image.Image, tolerance GoldenTolerance) (*GoldenDiff, error) {
+	bounds := got.Bounds()
+
+	if bounds.Size() != expected.Bounds().Size() {
+		return nil, fmt.Errorf("rendered %dx%d, but the golden image is %dx%d", bounds.Dx(), bounds.Dy(), expected.Bounds().Dx(), expected.Bounds().Dy())
+	}
+
+	offset := expected.Bounds().Min.Sub(bounds.Min)
+
+	diff := &GoldenDiff{
+		total: bounds.Dx() * bounds.Dy(),
+		img:   image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy())),
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			a := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+			b := color.NRGBAModel.Convert(expected.At(x+offset.X, y+offset.Y)).(color.NRGBA)
+
+			worst := channelDiff(a.R, b.R)
+
+			for _, channel := range []uint8{channelDiff(a.G, b.G), channelDiff(a.B, b.B), channelDiff(a.A, b.A)} {
+				if channel > worst {
+					worst = channel
+				}
+			}
+
+			if worst > diff.worst {
+				diff.worst = worst
+			}
+
+			if worst > tolerance.threshold {
+				diff.differing++
+				diff.img.SetRGBA(x-bounds.Min.X, y-bounds.Min.Y, color.RGBA{255, 0, 0, 255})
+			} else {
+				diff.img.SetRGBA(x-bounds.Min.X, y-bounds.Min.Y, color.RGBA{a.R / 4, a.G / 4, a.B / 4, 255})
+			}
+		}
+	}
+
+	return diff, nil
+}
+
+func channelDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+
+	return b - a
+}
+
+func readPng(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return png.Decode(file)
+}
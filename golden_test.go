@@ -0,0 +1,109 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func newGoldenTestImage(width, height int, colour color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, colour)
+		}
+	}
+
+	return img
+}
+
+func TestCompareGoldenIdentical(t *testing.T) {
+	img := newGoldenTestImage(16, 16, color.RGBA{10, 20, 30, 255})
+
+	diff, err := CompareGolden(img, img, DEFAULT_GOLDEN_TOLERANCE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.differing != 0 || diff.worst != 0 || !diff.Within(GoldenTolerance{}) {
+		t.Fatalf("identical images differ in %d pixels, by up to %d", diff.differing, diff.worst)
+	}
+}
+
+// differences under the threshold are noise, e.g. from a different rasteriser
+
+func TestCompareGoldenThreshold(t *testing.T) {
+	got := newGoldenTestImage(16, 16, color.RGBA{100, 100, 100, 255})
+	expected := newGoldenTestImage(16, 16, color.RGBA{104, 97, 100, 255})
+
+	diff, err := CompareGolden(got, expected, GoldenTolerance{threshold: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.differing != 0 || diff.worst != 4 {
+		t.Fatalf("got %d differing pixels, by up to %d, expected 0, by up to 4", diff.differing, diff.worst)
+	}
+
+	if diff, _ = CompareGolden(got, expected, GoldenTolerance{threshold: 3}); diff.differing != diff.total {
+		t.Fatalf("got %d differing pixels, expected all %d", diff.differing, diff.total)
+	}
+}
+
+// only so many pixels may differ by more than the threshold, and they're the ones shown in the diff image
+
+func TestCompareGoldenFraction(t *testing.T) {
+	got := newGoldenTestImage(10, 10, color.RGBA{0, 0, 0, 255})
+	expected := newGoldenTestImage(10, 10, color.RGBA{0, 0, 0, 255})
+
+	expected.SetRGBA(3, 4, color.RGBA{255, 255, 255, 255})
+	expected.SetRGBA(7, 1, color.RGBA{0, 200, 0, 255})
+
+	tolerance := GoldenTolerance{threshold: 8, fraction: .02}
+
+	diff, err := CompareGolden(got, expected, tolerance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.differing != 2 || !diff.Within(tolerance) {
+		t.Fatalf("got %d differing pixels, expected 2, within %v", diff.differing, tolerance.fraction)
+	}
+
+	tolerance.fraction = .01
+
+	if diff.Within(tolerance) {
+		t.Fatalf("%d differing pixels out of %d are within %v", diff.differing, diff.total, tolerance.fraction)
+	}
+
+	if diff.img.RGBAAt(3, 4) != (color.RGBA{255, 0, 0, 255}) || diff.img.RGBAAt(0, 0) == (color.RGBA{255, 0, 0, 255}) {
+		t.Fatal("the diff image doesn't show which pixels differ")
+	}
+}
+
+func TestCompareGoldenSize(t *testing.T) {
+	got := newGoldenTestImage(16, 16, color.RGBA{})
+	expected := newGoldenTestImage(16, 8, color.RGBA{})
+
+	if _, err := CompareGolden(got, expected, DEFAULT_GOLDEN_TOLERANCE); err == nil {
+		t.Fatal("images of different sizes were compared")
+	}
+}
+
+func TestParseGoldenTolerance(t *testing.T) {
+	tolerance, err := ParseGoldenTolerance("8, .001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tolerance != (GoldenTolerance{threshold: 8, fraction: .001}) {
+		t.Fatalf("got %+v", tolerance)
+	}
+
+	for _, invalid := range []string{"8", "256,0", "8,2", "a,b"} {
+		if _, err := ParseGoldenTolerance(invalid); err == nil {
+			t.Errorf("%q was parsed", invalid)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// headless, a single frame of a world is rendered offscreen and written to a PNG, without a window, a surface nor a swapchain
+// this is what golden-image regression tests run on machines without a GPU (or a display)
+
+const HEADLESS_FORMAT = wgpu.TextureFormat_RGBA8UnormSrgb
+
+// worlds are updated with a fixed timestep for this long before rendering, so things like doors settle wherever the flags put them
+// time is simulated rather than measured, so the same options always render the same frame
+
+const HEADLESS_SETTLE_TIME = 3
+const HEADLESS_TIMESTEP = 1. / 60
+
+type HeadlessOptions struct {
+	output string
+	world  string
+
+	position [3]float32 // of the player's feet, the camera being one Aylin above them
+	rotation [2]float32 // yaw and pitch, in radians
+
+	width  uint32
+	height uint32
+
+	flags string // applied before the world is updated
+
+	// reference PNG the render is compared with, if any
+
+	golden    string
+	tolerance GoldenTolerance
+}
+
+func ParseHeadlessOptions(output, world, camera, size, flags, golden, tolerance string) (*HeadlessOptions, error) {
+	options := &HeadlessOptions{
+		output: output,
+		world:  world,
+		flags:  strings.ReplaceAll(flags, ",", " "),
+		golden: golden,
+	}
+
+	var err error
+
+	if options.tolerance, err = ParseGoldenTolerance(tolerance); err != nil {
+		return nil, err
+	}
+
+	fields := strings.Split(camera, ",")
+
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("headless camera %q should be x,y,z,yaw,pitch", camera)
+	}
+
+	var values [5]float32
+
+	for i, field := range fields {
+		value, err := strconv.ParseFloat(strings.TrimSpace(field), 32)
+		if err != nil {
+			return nil, fmt.Errorf("headless camera %q: %v", camera, err)
+		}
+
+		values[i] = float32(value)
+	}
+
+	options.position = [3]float32{values[0], values[1], values[2]}
+	options.rotation = [2]float32{values[3], values[4]}
+
+	var width, height uint32
+
+	if _, err := fmt.Sscanf(size, "%dx%d", &width, &height); err != nil || width == 0 || height == 0 {
+		return nil, fmt.Errorf("headless size %q should be WIDTHxHEIGHT", size)
+	}
+
+	options.width = width
+	options.height = height
+
+	return options, nil
+}
+
+// stands in for the swapchain's configuration, which is what everything sized after the window goes by
+
+func (options *HeadlessOptions) Config() *wgpu.SwapChainDescriptor {
+	return &wgpu.SwapChainDescriptor{
+		Usage:  wgpu.TextureUsage_RenderAttachment | wgpu.TextureUsage_CopySrc,
+		Format: HEADLESS_FORMAT,
+		Width:  options.width,
+		Height: options.height,
+	}
+}
+
+func (state *State) RenderHeadless(options *HeadlessOptions) error {
+	if state.worlds.Get(options.world) == nil {
+		return fmt.Errorf("no world called %q", options.world)
+	}
+
+	log.Printf("Render %q headlessly to %s\n", options.world, options.output)
+
+	state.flags.Apply(options.flags)
+	state.worlds.SetActive([]string{options.world})
+
+	state.player.pos = options.position
+	state.player.rot = options.rotation
+
+	state.dt = HEADLESS_TIMESTEP
+
+	for t := float32(0); t < HEADLESS_SETTLE_TIME; t += HEADLESS_TIMESTEP {
+		state.prev_time += HEADLESS_TIMESTEP
+		state.worlds.Update()
+	}
+
+	target, err := state.device.CreateTexture(&wgpu.TextureDescriptor{
+		Label: "Headless target",
+		Size: wgpu.Extent3D{
+			Width:              state.config.Width,
+			Height:             state.config.Height,
+			DepthOrArrayLayers: 1,
+		},
+		MipLevelCount: 1,
+		SampleCount:   1,
+		Dimension:     wgpu.TextureDimension_2D,
+		Format:        state.config.Format,
+		Usage:         state.config.Usage,
+	})
+	if err != nil {
+		return err
+	}
+	defer target.Release()
+
+	view, err := target.CreateView(nil)
+	if err != nil {
+		return err
+	}
+	defer view.Release()
+
+	state.render(view)
+	state.frame.Execute()
+
+	img, err := ReadTexture(state, target, state.config.Format, state.config.Width, state.config.Height)
+	if err != nil {
+		return err
+	}
+
+	if err := writePng(options.output, img); err != nil {
+		return err
+	}
+
+	if options.golden == "" {
+		return nil
+	}
+
+	return compareHeadless(img, options)
+}
+
+// when the render differs from the golden image by more than is tolerated, the differences are written next to it
+
+func compareHeadless(img image.Image, options *HeadlessOptions) error {
+	golden, err := readPng(options.golden)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("there's no golden image at %s, render it first (tools/goldens.sh --update)", options.golden)
+	} else if err != nil {
+		return fmt.Errorf("golden image: %w", err)
+	}
+
+	diff, err := CompareGolden(img, golden, options.tolerance)
+	if err != nil {
+		return fmt.Errorf("%s: %w", options.golden, err)
+	}
+
+	log.Printf("%d of %d pixels differ from %s by more than %d, by up to %d\n", diff.differing, diff.total, options.golden, options.tolerance.threshold, diff.worst)
+
+	if diff.Within(options.tolerance) {
+		return nil
+	}
+
+	diff_path := strings.TrimSuffix(options.output, ".png") + ".diff.png"
+
+	if err := writePng(diff_path, diff.img); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%s differs from %s in more than %g of its pixels, see %s", options.output, options.golden, options.tolerance.fraction, diff_path)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// instances of a model drawn in a single draw call, each with its own transform and tint
+// transforms are in the model's space, i.e. in metres, on top of the scene node's transform
+
+type InstanceData struct {
+	transform [4][4]float32
+	tint      [4]float32
+}
+
+var INSTANCE_IDENTITY = InstanceData{
+	transform: NewMat().Data,
+	tint:      [4]float32{1, 1, 1, 1},
+}
+
+var INSTANCE_VBO_LAYOUT = wgpu.VertexBufferLayout{
+	ArrayStride: uint64(unsafe.Sizeof(InstanceData{})),
+	StepMode:    wgpu.VertexStepMode_Instance,
+	Attributes: []wgpu.VertexAttribute{
+		{ // transform, one column at a time
+			Format:         wgpu.VertexFormat_Float32x4,
+			Offset:         0,
+			ShaderLocation: 2,
+		},
+		{
+			Format:         wgpu.VertexFormat_Float32x4,
+			Offset:         4 * 4,
+			ShaderLocation: 3,
+		},
+		{
+			Format:         wgpu.VertexFormat_Float32x4,
+			Offset:         4 * 8,
+			ShaderLocation: 4,
+		},
+		{
+			Format:         wgpu.VertexFormat_Float32x4,
+			Offset:         4 * 12,
+			ShaderLocation: 5,
+		},
+		{ // tint
+			Format:         wgpu.VertexFormat_Float32x4,
+			Offset:         4 * 16,
+			ShaderLocation: 6,
+		},
+	},
+}
+
+const MIN_INSTANCE_CAPACITY = 16
+
+type Instances struct {
+	state *State
+	label string
+
+	// instances are kept packed, so removing one moves the last one in its place
+	// ids stay the same whatever happens to the others
+
+	data    []InstanceData
+	ids     []int
+	slots   map[int]int
+	next_id int
+
+	buffer   *wgpu.Buffer
+	capacity int
+	dirty    bool
+}
+
+func NewInstances(state *State, label string) *Instances {
+	return &Instances{
+		state: state,
+		label: label,
+		slots: make(map[int]int),
+	}
+}
+
+func (instances *Instances) Add(transform *Mat, tint [4]float32) int {
+	id := instances.next_id
+	instances.next_id++
+
+	instances.slots[id] = len(instances.data)
+	instances.data = append(instances.data, InstanceData{transform: transform.Data, tint: tint})
+	instances.ids = append(instances.ids, id)
+	instances.dirty = true
+
+	return id
+}
+
+func (instances *Instances) Set(id int, transform *Mat, tint [4]float32) {
+	slot, ok := instances.slots[id]
+
+	if !ok {
+		return
+	}
+
+	instances.data[slot] = InstanceData{transform: transform.Data, tint: tint}
+	instances.dirty = true
+}
+
+func (instances *Instances) Remove(id int) {
+	slot, ok := instances.slots[id]
+
+	if !ok {
+		return
+	}
+
+	last := len(instances.data) - 1
+
+	instances.data[slot] = instances.data[last]
+	instances.ids[slot] = instances.ids[last]
+	instances.slots[instances.ids[slot]] = slot
+
+	instances.data = instances.data[:last]
+	instances.ids = instances.ids[:last]
+	delete(instances.slots, id)
+
+	instances.dirty = true
+}
+
+func (instances *Instances) Count() int {
+	return len(instances.data)
+}
+
+// write the instances to the GPU if they've changed, growing the buffer if there's no room left
+
+func (instances *Instances) upload() error {
+	if !instances.dirty || len(instances.data) == 0 {
+		return nil
+	}
+
+	if len(instances.data) > instances.capacity {
+		capacity := instances.capacity
+
+		if capacity < MIN_INSTANCE_CAPACITY {
+			capacity = MIN_INSTANCE_CAPACITY
+		}
+
+		for capacity < len(instances.data) {
+			capacity *= 2
+		}
+
+		buffer, err := instances.state.device.CreateBuffer(&wgpu.BufferDescriptor{
+			Label: fmt.Sprintf("Instances (%s)", instances.label),
+			Size:  uint64(capacity) * uint64(unsafe.Sizeof(InstanceData{})),
+			Usage: wgpu.BufferUsage_Vertex | wgpu.BufferUsage_CopyDst,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if instances.buffer != nil {
+			instances.buffer.Release()
+		}
+
+		instances.buffer = buffer
+		instances.capacity = capacity
+	}
+
+	instances.state.queue.WriteBuffer(instances.buffer, 0, wgpu.ToBytes(instances.data))
+	instances.dirty = false
+
+	return nil
+}
+
+func (instances *Instances) Release() {
+	if instances.buffer != nil {
+		instances.buffer.Release()
+	}
+}
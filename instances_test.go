@@ -0,0 +1,133 @@
+package main
+
+import "testing"
+
+// instances are made without a GPU, as nothing is uploaded until they're drawn
+
+func newTestInstances() *Instances {
+	return NewInstances(nil, "test")
+}
+
+func instanceTint(i int) [4]float32 {
+	return [4]float32{float32(i), 0, 0, 1}
+}
+
+// every id must still lead to the instance it was given for, wherever it's been moved to
+
+func assertInstances(t *testing.T, instances *Instances, expected map[int]int) {
+	t.Helper()
+
+	if instances.Count() != len(expected) {
+		t.Fatalf("got %d instances, expected %d", instances.Count(), len(expected))
+	}
+
+	for id, tint := range expected {
+		slot, ok := instances.slots[id]
+
+		if !ok {
+			t.Fatalf("instance %d is gone", id)
+		}
+
+		if instances.ids[slot] != id {
+			t.Fatalf("slot %d of instance %d belongs to instance %d", slot, id, instances.ids[slot])
+		}
+
+		if got := instances.data[slot].tint; got != instanceTint(tint) {
+			t.Fatalf("instance %d has tint %v, expected %v", id, got, instanceTint(tint))
+		}
+	}
+}
+
+func TestInstancesAdd(t *testing.T) {
+	instances := newTestInstances()
+	expected := make(map[int]int)
+
+	for i := 0; i < 4; i++ {
+		id := instances.Add(NewMat(), instanceTint(i))
+
+		if _, ok := expected[id]; ok {
+			t.Fatalf("id %d was given twice", id)
+		}
+
+		expected[id] = i
+	}
+
+	assertInstances(t, instances, expected)
+
+	if !instances.dirty {
+		t.Fatal("adding instances doesn't mark them to be uploaded")
+	}
+}
+
+// removing an instance moves the last one into its slot, which mustn't change what its id leads to
+
+func TestInstancesRemove(t *testing.T) {
+	instances := newTestInstances()
+	var ids []int
+
+	for i := 0; i < 5; i++ {
+		ids = append(ids, instances.Add(NewMat(), instanceTint(i)))
+	}
+
+	instances.Remove(ids[1])
+	assertInstances(t, instances, map[int]int{ids[0]: 0, ids[2]: 2, ids[3]: 3, ids[4]: 4})
+
+	// the last one, which doesn't need moving
+
+	instances.Remove(ids[3])
+	assertInstances(t, instances, map[int]int{ids[0]: 0, ids[2]: 2, ids[4]: 4})
+
+	// twice, and unknown ids, do nothing
+
+	instances.Remove(ids[3])
+	instances.Remove(42)
+	assertInstances(t, instances, map[int]int{ids[0]: 0, ids[2]: 2, ids[4]: 4})
+
+	// ids aren't reused, even once their instances are gone
+
+	id := instances.Add(NewMat(), instanceTint(5))
+
+	for _, old := range ids {
+		if id == old {
+			t.Fatalf("id %d was reused", id)
+		}
+	}
+
+	assertInstances(t, instances, map[int]int{ids[0]: 0, ids[2]: 2, ids[4]: 4, id: 5})
+
+	for _, id := range []int{ids[0], ids[2], ids[4], id} {
+		instances.Remove(id)
+	}
+
+	assertInstances(t, instances, map[int]int{})
+}
+
+// setting an instance which has been moved by a removal changes it, and only it
+
+func TestInstancesSet(t *testing.T) {
+	instances := newTestInstances()
+	var ids []int
+
+	for i := 0; i < 3; i++ {
+		ids = append(ids, instances.Add(NewMat(), instanceTint(i)))
+	}
+
+	instances.Remove(ids[0])
+	instances.dirty = false
+
+	transform := NewMat().Translation(1, 2, 3)
+	instances.Set(ids[2], transform, instanceTint(7))
+
+	assertInstances(t, instances, map[int]int{ids[1]: 1, ids[2]: 7})
+
+	if instances.data[instances.slots[ids[2]]].transform != transform.Data {
+		t.Fatal("setting an instance doesn't change its transform")
+	}
+
+	if !instances.dirty {
+		t.Fatal("setting an instance doesn't mark it to be uploaded")
+	}
+
+	instances.Set(ids[0], NewMat(), instanceTint(9))
+	assertInstances(t, instances, map[int]int{ids[1]: 1, ids[2]: 7})
+}
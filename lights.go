@@ -0,0 +1,276 @@
+package main
+
+import (
+	"log"
+	"math"
+	"unsafe"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// dynamic lights, shaded per pixel on top of the baked lightmaps
+// they're in world space (i.e. in Aylins), or in a scene node's space if they're attached to one
+
+type LightKind uint32
+
+const (
+	LIGHT_DIRECTIONAL LightKind = iota
+	LIGHT_POINT
+	LIGHT_SPOT
+)
+
+const MAX_LIGHTS = 16
+
+type Light struct {
+	kind  LightKind
+	node  *SceneNode // lights attached to a node are only on while it's visible
+	world string     // lights in a world are only on while it can be seen, and only light that world
+
+	position  [3]float32 // point and spot lights
+	direction [3]float32 // directional and spot lights, towards where the light goes
+	colour    [3]float32
+	intensity float32
+
+	radius float32 // past which point and spot lights don't light anything
+	inner  float32 // cone angles of spot lights, in radians
+	outer  float32
+
+	enabled bool
+
+	// directional and spot lights which cast shadows do so from the models in casters
+
+	shadows      bool
+	casters      *SceneNode
+	shadow_layer int // first layer of the light's shadow map this frame, or -1 if it has none
+}
+
+func (light *Light) On() bool {
+	return light.enabled && (light.node == nil || light.node.Visible())
+}
+
+// position and normalised direction of the light in world space
+
+func (light *Light) WorldSpace() ([3]float32, [3]float32) {
+	position := light.position
+	direction := light.direction
+
+	if light.node != nil {
+		position = light.node.World().TransformPoint(position)
+		direction = light.node.World().TransformVector(direction)
+	}
+
+	return position, normalise(direction)
+}
+
+// the layout of these must match the structs in shaders/regular.wgsl
+
+type LightData struct {
+	position  [3]float32
+	kind      uint32
+	direction [3]float32
+	radius    float32
+	colour    [3]float32
+	intensity float32
+	cone      [2]float32 // cosines of the inner and outer angles
+	shadow    int32
+	_         float32
+}
+
+type LightUniforms struct {
+	eye    [3]float32
+	count  uint32
+	lights [MAX_LIGHTS]LightData
+
+	shadow_vps    [SHADOW_LAYERS][4][4]float32
+	shadow_texel  float32
+	shadow_filter int32
+	_             [2]float32
+}
+
+type Lights struct {
+	state  *State
+	lights []*Light
+
+	buffer            *wgpu.Buffer
+	bind_group_layout *SharedLayout
+	bind_group        *wgpu.BindGroup
+
+	shadows *ShadowMap
+	warned  bool
+}
+
+func NewLights(state *State, shadow_resolution uint32, shadow_filter int) (*Lights, error) {
+	lights := &Lights{state: state}
+	var err error
+
+	if lights.shadows, err = NewShadowMap(state, shadow_resolution, shadow_filter); err != nil {
+		return nil, err
+	}
+
+	if lights.buffer, err = state.device.CreateBuffer(&wgpu.BufferDescriptor{
+		Label: "Lights",
+		Size:  uint64(unsafe.Sizeof(LightUniforms{})),
+		Usage: wgpu.BufferUsage_Uniform | wgpu.BufferUsage_CopyDst,
+	}); err != nil {
+		lights.shadows.Release()
+		return nil, err
+	}
+
+	if lights.bind_group_layout, err = NewSharedLayout(state, "Bind group layout (lights)",
+		[]wgpu.BindGroupLayoutEntry{
+			{
+				Binding:    0,
+				Visibility: wgpu.ShaderStage_Fragment,
+				Buffer: wgpu.BufferBindingLayout{
+					Type:           wgpu.BufferBindingType_Uniform,
+					MinBindingSize: uint64(unsafe.Sizeof(LightUniforms{})),
+				},
+			},
+			{ // shadow maps
+				Binding:    1,
+				Visibility: wgpu.ShaderStage_Fragment,
+				Texture: wgpu.TextureBindingLayout{
+					Multisampled:  false,
+					ViewDimension: wgpu.TextureViewDimension_2DArray,
+					SampleType:    wgpu.TextureSampleType_Depth,
+				},
+			},
+			{ // comparison sampler
+				Binding:    2,
+				Visibility: wgpu.ShaderStage_Fragment,
+				Sampler: wgpu.SamplerBindingLayout{
+					Type: wgpu.SamplerBindingType_Comparison,
+				},
+			},
+		},
+	); err != nil {
+		lights.shadows.Release()
+		lights.buffer.Release()
+		return nil, err
+	}
+
+	if lights.bind_group, err = state.device.CreateBindGroup(&wgpu.BindGroupDescriptor{
+		Label:  "Bind group (lights)",
+		Layout: lights.bind_group_layout.layout,
+		Entries: []wgpu.BindGroupEntry{
+			{
+				Binding: 0,
+				Buffer:  lights.buffer,
+				Size:    wgpu.WholeSize,
+			},
+			{
+				Binding:     1,
+				TextureView: lights.shadows.texture.view,
+			},
+			{
+				Binding: 2,
+				Sampler: lights.shadows.texture.sampler,
+			},
+		},
+	}); err != nil {
+		lights.shadows.Release()
+		lights.buffer.Release()
+		lights.bind_group_layout.Release()
+		return nil, err
+	}
+
+	return lights, nil
+}
+
+// worlds seen through portals are lit (and cast shadows) just like active ones, so they don't change once gone through
+
+func (lights *Lights) on(light *Light) bool {
+	return light.On() && (light.world == "" || lights.state.portals.Seen(light.world))
+}
+
+func (lights *Lights) Add(light *Light) *Light {
+	lights.lights = append(lights.lights, light)
+	return light
+}
+
+func (lights *Lights) Remove(light *Light) {
+	for i, other := range lights.lights {
+		if other == light {
+			lights.lights = append(lights.lights[:i], lights.lights[i+1:]...)
+			return
+		}
+	}
+}
+
+// upload the lights which are on for the next pass, as seen from eye (for specular highlights)
+
+func (lights *Lights) Upload(eye [3]float32) {
+	uniforms := LightUniforms{
+		eye:           eye,
+		shadow_texel:  1 / float32(lights.shadows.resolution),
+		shadow_filter: int32(lights.shadows.filter),
+	}
+
+	for i, vp := range lights.shadows.vps {
+		uniforms.shadow_vps[i] = vp.Data
+	}
+
+	for _, light := range lights.lights {
+		if !lights.on(light) || (light.world != "" && light.world != lights.state.portals.rendering) {
+			continue
+		}
+
+		if uniforms.count >= MAX_LIGHTS {
+			if !lights.warned {
+				log.Printf("More than %d lights are on, ignoring the rest\n", MAX_LIGHTS)
+				lights.warned = true
+			}
+
+			break
+		}
+
+		position, direction := light.WorldSpace()
+		shadow := int32(-1)
+
+		if light.shadows {
+			shadow = int32(light.shadow_layer)
+		}
+
+		uniforms.lights[uniforms.count] = LightData{
+			position:  position,
+			kind:      uint32(light.kind),
+			direction: direction,
+			radius:    light.radius,
+			colour:    light.colour,
+			intensity: light.intensity,
+			cone:      [2]float32{float32(math.Cos(float64(light.inner))), float32(math.Cos(float64(light.outer)))},
+			shadow:    shadow,
+		}
+
+		uniforms.count++
+	}
+
+	lights.state.frame.Upload(lights.buffer, wgpu.ToBytes([]LightUniforms{uniforms}))
+}
+
+// render the shadow maps of the lights which cast shadows, before anything they light is rendered
+
+func (lights *Lights) RenderShadows() {
+	var on []*Light
+
+	for _, light := range lights.lights {
+		light.shadow_layer = -1
+
+		if lights.on(light) {
+			on = append(on, light)
+		}
+	}
+
+	lights.shadows.Render(on)
+}
+
+func (lights *Lights) Set(render_pass *wgpu.RenderPassEncoder) {
+	render_pass.SetBindGroup(2, lights.bind_group, nil)
+}
+
+func (lights *Lights) Release() {
+	lights.bind_group.Release()
+	lights.bind_group_layout.Release()
+	lights.buffer.Release()
+	lights.shadows.Release()
+}
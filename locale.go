@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	_ "embed"
+)
+
+//go:embed res/strings.csv
+var strings_csv []byte
+
+// language used when a string is missing in the selected one
+// it's also the one the story was originally written in
+
+const DEFAULT_LOCALE = "fr"
+
+// string table with one column per locale, keyed by string ID
+
+type StringTable struct {
+	locales []string
+	locale  string
+	entries map[string]map[string]string
+}
+
+func NewStringTable(table_csv []byte, locale string) (*StringTable, error) {
+	records, err := csv.NewReader(bytes.NewReader(table_csv)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) < 1 || len(records[0]) < 2 || records[0][0] != "id" {
+		return nil, fmt.Errorf("string table must start with an \"id\" column followed by one column per locale")
+	}
+
+	table := &StringTable{
+		locales: records[0][1:],
+		entries: make(map[string]map[string]string),
+	}
+
+	for i := 1; i < len(records); i++ {
+		record := records[i]
+		id := record[0]
+
+		if _, ok := table.entries[id]; ok {
+			return nil, fmt.Errorf("duplicate string %q on line %d", id, i+1)
+		}
+
+		table.entries[id] = make(map[string]string)
+
+		for j, locale := range table.locales {
+			if record[j+1] != "" {
+				table.entries[id][locale] = record[j+1]
+			}
+		}
+	}
+
+	table.SetLocale(locale)
+	return table, nil
+}
+
+func (table *StringTable) SetLocale(locale string) {
+	for _, available := range table.locales {
+		if available == locale {
+			table.locale = locale
+			return
+		}
+	}
+
+	log.Printf("Locale %q not available (have %s), falling back to %q\n", locale, strings.Join(table.locales, ", "), DEFAULT_LOCALE)
+	table.locale = DEFAULT_LOCALE
+}
+
+func (table *StringTable) Has(id string) bool {
+	_, ok := table.entries[id]
+	return ok
+}
+
+// get a string in the current locale, falling back to the default locale and then to the ID itself
+
+func (table *StringTable) Get(id string) string {
+	entry, ok := table.entries[id]
+
+	if !ok {
+		return id
+	}
+
+	if value, ok := entry[table.locale]; ok {
+		return value
+	}
+
+	if value, ok := entry[DEFAULT_LOCALE]; ok {
+		return value
+	}
+
+	return id
+}
+
+// list of strings which aren't translated in every locale, formatted as "id (locale)"
+
+func (table *StringTable) Missing() []string {
+	var missing []string
+
+	for id, entry := range table.entries {
+		for _, locale := range table.locales {
+			if _, ok := entry[locale]; !ok {
+				missing = append(missing, fmt.Sprintf("%s (%s)", id, locale))
+			}
+		}
+	}
+
+	sort.Strings(missing)
+	return missing
+}
+
+// pick the locale from the command line, or from the environment if not set there
+// LANG & co. look like "en_US.UTF-8", so only keep the language part
+
+func SelectLocale(flag_locale string) string {
+	if flag_locale != "" {
+		return flag_locale
+	}
+
+	for _, env := range []string{"QUOICOUBEH_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		value := os.Getenv(env)
+
+		if value == "" || value == "C" || value == "POSIX" {
+			continue
+		}
+
+		if i := strings.IndexAny(value, "_.@"); i >= 0 {
+			value = value[:i]
+		}
+
+		return strings.ToLower(value)
+	}
+
+	return DEFAULT_LOCALE
+}
+
+// check every locale has every string and that dialogues only refer to existing strings
+// returns the number of problems found
+
+func CheckStrings(state *State) int {
+	problems := 0
+
+	for _, missing := range state.strings.Missing() {
+		log.Printf("Missing string: %s\n", missing)
+		problems++
+	}
+
+	if _, err := NewDialogue(state, dialogues_csv, dialogue_choices_csv); err != nil {
+		log.Printf("Invalid dialogues: %v\n", err)
+		problems++
+	}
+
+	return problems
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/csv"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+//go:embed res/materials.csv
+var materials_csv []byte
+
+// textures materials can use, named by their path in res/
+// only those materials refer to are embedded, so a texture must be added here along with the first material using it
+
+//go:embed res/alexis-room-lightmap.png res/apat-lightmap.png res/obama-lightmap.png
+var textures_fs embed.FS
+
+// what a model looks like, i.e. which shader permutation draws it, with which textures and parameters, and how it's blended
+// materials are defined in res/materials.csv, one per line, and models refer to them by name
+
+// texture slots, each bound after the material's uniforms and sampler, and defining a name for the shader when filled
+// e.g. a material with a lightmap gets LIGHTMAP defined, and its lightmap bound to binding 3
+
+type MaterialSlot struct {
+	name   string
+	define string
+	srgb   bool // colour textures are sRGB, normal maps are linear
+}
+
+var MATERIAL_SLOTS = [...]MaterialSlot{
+	{"albedo", "ALBEDO_MAP", true},
+	{"lightmap", "LIGHTMAP", true},
+	{"normal", "NORMAL_MAP", false},
+	{"emissive", "EMISSIVE_MAP", true},
+}
+
+const MATERIAL_UNIFORM_BINDING = 0
+const MATERIAL_SAMPLER_BINDING = 1
+const MATERIAL_FIRST_SLOT_BINDING = 2
+
+// parameters with where they are in the uniforms and how many components they have
+// the layout must match the Material struct in shaders/include/material.wgsl
+
+type MaterialParam struct {
+	offset     int
+	components int
+}
+
+const MATERIAL_PARAM_FLOATS = 12
+
+var MATERIAL_PARAMS = map[string]MaterialParam{
+	"colour":            {0, 4},
+	"emissive":          {4, 3},
+	"emissive_strength": {7, 1},
+	"specular":          {8, 1},
+	"shininess":         {9, 1},
+}
+
+var DEFAULT_MATERIAL_PARAMS = [MATERIAL_PARAM_FLOATS]float32{
+	1, 1, 1, 1, // colour
+	0, 0, 0, 1, // emissive and its strength
+	.25, 32, // specular and shininess
+}
+
+var MATERIAL_BLEND_MODES = map[string]BlendMode{
+	"replace":       BLEND_REPLACE,
+	"alpha":         BLEND_ALPHA,
+	"premultiplied": BLEND_PREMULTIPLIED,
+	"additive":      BLEND_ADDITIVE,
+}
+
+var MATERIAL_CULL_MODES = map[string]wgpu.CullMode{
+	"none":  wgpu.CullMode_None,
+	"front": wgpu.CullMode_Front,
+	"back":  wgpu.CullMode_Back,
+}
+
+// topology isn't part of a material, as models only have triangle index buffers, and WebGPU has no polygon mode to draw those as lines
+// wireframes would need models with index buffers of lines and a pipeline of their own, see PipelineDescriptor
+
+type MaterialDefinition struct {
+	name    string
+	shader  string
+	defines ShaderDefines
+
+	blend     BlendMode
+	cull_mode wgpu.CullMode
+
+	textures [len(MATERIAL_SLOTS)]string // paths in res/, empty for slots which aren't filled
+	params   [MATERIAL_PARAM_FLOATS]float32
+}
+
+// materials are given one per line, e.g.:
+// misty_glass,regular.wgsl,FOG FOG_DENSITY=.1,alpha,back,glass.png,,,,"colour=1,1,1,.5 specular=1"
+// defines are separated by spaces (NAME or NAME=value), as are parameters, whose components are separated by commas
+
+func LoadMaterials(materials_csv []byte) (map[string]*MaterialDefinition, error) {
+	records, err := csv.NewReader(bytes.NewReader(materials_csv)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	expected := 5 + len(MATERIAL_SLOTS) + 1
+	definitions := make(map[string]*MaterialDefinition)
+
+	for i := 1; i < len(records); i++ {
+		record := records[i]
+
+		if len(record) != expected {
+			return nil, fmt.Errorf("materials: line %d has %d fields, expected %d", i+1, len(record), expected)
+		}
+
+		definition := &MaterialDefinition{
+			name:    record[0],
+			shader:  record[1],
+			defines: make(ShaderDefines),
+			params:  DEFAULT_MATERIAL_PARAMS,
+		}
+
+		if _, ok := definitions[definition.name]; ok {
+			return nil, fmt.Errorf("materials: line %d: material %q defined twice", i+1, definition.name)
+		}
+
+		for _, field := range strings.Fields(record[2]) {
+			name, value, _ := strings.Cut(field, "=")
+			definition.defines[name] = value
+		}
+
+		var ok bool
+
+		if definition.blend, ok = MATERIAL_BLEND_MODES[record[3]]; !ok {
+			return nil, fmt.Errorf("materials: line %d: unknown blend mode %q", i+1, record[3])
+		}
+
+		if definition.cull_mode, ok = MATERIAL_CULL_MODES[record[4]]; !ok {
+			return nil, fmt.Errorf("materials: line %d: unknown cull mode %q", i+1, record[4])
+		}
+
+		for j, slot := range MATERIAL_SLOTS {
+			definition.textures[j] = record[5+j]
+
+			if definition.textures[j] == "" {
+				continue
+			}
+
+			if _, err := fs.Stat(textures_fs, "res/"+definition.textures[j]); err != nil {
+				return nil, fmt.Errorf("materials: line %d: %s texture %q isn't embedded", i+1, slot.name, definition.textures[j])
+			}
+
+			definition.defines[slot.define] = ""
+		}
+
+		for _, field := range strings.Fields(record[expected-1]) {
+			key, value, _ := strings.Cut(field, "=")
+			param, ok := MATERIAL_PARAMS[key]
+
+			if !ok {
+				return nil, fmt.Errorf("materials: line %d: no parameter %q", i+1, key)
+			}
+
+			components := strings.Split(value, ",")
+
+			if len(components) > param.components {
+				return nil, fmt.Errorf("materials: line %d: %q has %d components, expected at most %d", i+1, key, len(components), param.components)
+			}
+
+			for j, component := range components {
+				parsed, err := strconv.ParseFloat(component, 32)
+				if err != nil {
+					return nil, fmt.Errorf("materials: line %d: %v", i+1, err)
+				}
+
+				definition.params[param.offset+j] = float32(parsed)
+			}
+		}
+
+		definitions[definition.name] = definition
+	}
+
+	return definitions, nil
+}
+
+type Material struct {
+	state      *State
+	definition *MaterialDefinition
+
+	pipeline    *Pipeline
+	textures    [len(MATERIAL_SLOTS)]*Texture
+	sampler     *wgpu.Sampler
+	uniform_buf *wgpu.Buffer
+	bind_group  *wgpu.BindGroup
+}
+
+// materials are usually asked for through the resource cache instead, so models using the same one share it
+
+func NewMaterial(state *State, name string) (*Material, error) {
+	definition, ok := state.materials[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown material %q", name)
+	}
+
+	material := &Material{
+		state:      state,
+		definition: definition,
+	}
+
+	// pipeline state which isn't the material's is the regular pipeline's
+	// blended materials don't write depth, so what's behind them is still drawn if it comes after
+
+	descriptor := state.regular_pipeline.descriptor
+	descriptor.label = fmt.Sprintf("Material %s", name)
+	descriptor.shader = definition.shader
+	descriptor.defines = definition.defines
+	descriptor.blend = definition.blend
+	descriptor.cull_mode = definition.cull_mode
+	descriptor.depth_write = definition.blend == BLEND_REPLACE
+
+	var err error
+
+	if material.pipeline, err = state.resources.Pipeline(descriptor); err != nil {
+		return nil, fmt.Errorf("material %q: %w", name, err)
+	}
+
+	if material.sampler, err = state.resources.Sampler(DEFAULT_TEXTURE_OPTIONS); err != nil {
+		material.Release()
+		return nil, err
+	}
+
+	for i, slot := range MATERIAL_SLOTS {
+		path := definition.textures[i]
+
+		if path == "" {
+			continue
+		}
+
+		buf, err := textures_fs.ReadFile("res/" + path)
+		if err != nil {
+			material.Release()
+			return nil, fmt.Errorf("material %q: %w", name, err)
+		}
+
+		options := DEFAULT_TEXTURE_OPTIONS
+		options.srgb = slot.srgb
+
+		if material.textures[i], err = state.resources.Texture(path, buf, options); err != nil {
+			material.Release()
+			return nil, fmt.Errorf("material %q: %s: %w", name, path, err)
+		}
+	}
+
+	if material.uniform_buf, err = state.resources.Buffer(fmt.Sprintf("Uniforms (material %s)", name), wgpu.ToBytes(definition.params[:]), wgpu.BufferUsage_Uniform); err != nil {
+		material.Release()
+		return nil, err
+	}
+
+	entries, err := material.bindGroupEntries()
+	if err != nil {
+		material.Release()
+		return nil, fmt.Errorf("material %q: %w", name, err)
+	}
+
+	if material.bind_group, err = state.device.CreateBindGroup(&wgpu.BindGroupDescriptor{
+		Label:   fmt.Sprintf("Bind group (material %s)", name),
+		Layout:  material.pipeline.bind_group_layout,
+		Entries: entries,
+	}); err != nil {
+		material.Release()
+		return nil, err
+	}
+
+	return material, nil
+}
+
+// bind whatever the shader declares in group 0, which is what its pipeline's layout was made from
+
+func (material *Material) bindGroupEntries() ([]wgpu.BindGroupEntry, error) {
+	var entries []wgpu.BindGroupEntry
+
+	for _, binding := range material.pipeline.shader.reflection.bindings {
+		if binding.group != 0 {
+			continue
+		}
+
+		entry := wgpu.BindGroupEntry{Binding: binding.binding}
+		slot := int(binding.binding) - MATERIAL_FIRST_SLOT_BINDING
+
+		switch {
+		case binding.binding == MATERIAL_UNIFORM_BINDING:
+			entry.Buffer = material.uniform_buf
+			entry.Size = wgpu.WholeSize
+
+		case binding.binding == MATERIAL_SAMPLER_BINDING:
+			entry.Sampler = material.sampler
+
+		case slot >= 0 && slot < len(MATERIAL_SLOTS):
+			if material.textures[slot] == nil {
+				return nil, fmt.Errorf("%s is declared, but there's no %s texture", binding.name, MATERIAL_SLOTS[slot].name)
+			}
+
+			entry.TextureView = material.textures[slot].view
+
+		default:
+			return nil, fmt.Errorf("%s is at binding %d, which materials don't use", binding.name, binding.binding)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// components of one of the material's parameters, e.g. its emissive colour
+
+func (material *Material) Param(name string) []float32 {
+	param := MATERIAL_PARAMS[name]
+	return material.definition.params[param.offset : param.offset+param.components]
+}
+
+func (material *Material) Set(render_pass *wgpu.RenderPassEncoder) {
+	material.pipeline.Set(render_pass, material.bind_group)
+}
+
+func (material *Material) Release() {
+	resources := material.state.resources
+
+	if material.bind_group != nil {
+		material.bind_group.Release()
+	}
+
+	if material.uniform_buf != nil {
+		resources.Release(material.uniform_buf)
+	}
+
+	for _, texture := range material.textures {
+		if texture != nil {
+			resources.Release(texture)
+		}
+	}
+
+	if material.sampler != nil {
+		resources.Release(material.sampler)
+	}
+
+	resources.Release(material.pipeline)
+}
@@ -131,3 +131,122 @@ func (mat *Mat) Perspective(fov_y, aspect, near, far float32) *Mat {
 
 	return mat.Frustum(-frustum_x*near, frustum_x*near, -frustum_y*near, frustum_y*near, near, far)
 }
+
+func (mat *Mat) Orthographic(left, right, bottom, top, near, far float32) *Mat {
+	dx := right - left
+	dy := top - bottom
+	dz := far - near
+
+	mat.Identity()
+
+	mat.Data[0][0] = 2 / dx
+	mat.Data[1][1] = 2 / dy
+	mat.Data[2][2] = -2 / dz
+
+	mat.Data[3][0] = -(right + left) / dx
+	mat.Data[3][1] = -(top + bottom) / dy
+	mat.Data[3][2] = -(far + near) / dz
+
+	return mat
+}
+
+// view matrix of a camera at eye looking towards centre, looking down -Z like the other views
+
+func (mat *Mat) LookAt(eye, centre, up [3]float32) *Mat {
+	f := normalise([3]float32{centre[0] - eye[0], centre[1] - eye[1], centre[2] - eye[2]})
+	s := normalise(cross(f, up))
+	u := cross(s, f)
+
+	mat.Identity()
+
+	for i := 0; i < 3; i++ {
+		mat.Data[i][0] = s[i]
+		mat.Data[i][1] = u[i]
+		mat.Data[i][2] = -f[i]
+	}
+
+	mat.Data[3][0] = -(s[0]*eye[0] + s[1]*eye[1] + s[2]*eye[2])
+	mat.Data[3][1] = -(u[0]*eye[0] + u[1]*eye[1] + u[2]*eye[2])
+	mat.Data[3][2] = f[0]*eye[0] + f[1]*eye[1] + f[2]*eye[2]
+
+	return mat
+}
+
+func normalise(vector [3]float32) [3]float32 {
+	length := float32(math.Sqrt(float64(vector[0]*vector[0] + vector[1]*vector[1] + vector[2]*vector[2])))
+
+	if length == 0 {
+		return vector
+	}
+
+	return [3]float32{vector[0] / length, vector[1] / length, vector[2] / length}
+}
+
+func (mat *Mat) Copy() *Mat {
+	return &Mat{Data: mat.Data}
+}
+
+func (mat *Mat) TransformPoint(point [3]float32) [3]float32 {
+	var res [3]float32
+
+	for j := 0; j < 3; j++ {
+		res[j] = mat.Data[0][j]*point[0] + mat.Data[1][j]*point[1] + mat.Data[2][j]*point[2] + mat.Data[3][j]
+	}
+
+	return res
+}
+
+func (mat *Mat) TransformVector(vector [3]float32) [3]float32 {
+	var res [3]float32
+
+	for j := 0; j < 3; j++ {
+		res[j] = mat.Data[0][j]*vector[0] + mat.Data[1][j]*vector[1] + mat.Data[2][j]*vector[2]
+	}
+
+	return res
+}
+
+// general 4x4 inverse by cofactor expansion, leaves the matrix untouched if it's singular
+
+func (mat *Mat) Inverse() *Mat {
+	var m [16]float32
+
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			m[i*4+j] = mat.Data[i][j]
+		}
+	}
+
+	var inv [16]float32
+
+	inv[0] = m[5]*m[10]*m[15] - m[5]*m[11]*m[14] - m[9]*m[6]*m[15] + m[9]*m[7]*m[14] + m[13]*m[6]*m[11] - m[13]*m[7]*m[10]
+	inv[4] = -m[4]*m[10]*m[15] + m[4]*m[11]*m[14] + m[8]*m[6]*m[15] - m[8]*m[7]*m[14] - m[12]*m[6]*m[11] + m[12]*m[7]*m[10]
+	inv[8] = m[4]*m[9]*m[15] - m[4]*m[11]*m[13] - m[8]*m[5]*m[15] + m[8]*m[7]*m[13] + m[12]*m[5]*m[11] - m[12]*m[7]*m[9]
+	inv[12] = -m[4]*m[9]*m[14] + m[4]*m[10]*m[13] + m[8]*m[5]*m[14] - m[8]*m[6]*m[13] - m[12]*m[5]*m[10] + m[12]*m[6]*m[9]
+	inv[1] = -m[1]*m[10]*m[15] + m[1]*m[11]*m[14] + m[9]*m[2]*m[15] - m[9]*m[3]*m[14] - m[13]*m[2]*m[11] + m[13]*m[3]*m[10]
+	inv[5] = m[0]*m[10]*m[15] - m[0]*m[11]*m[14] - m[8]*m[2]*m[15] + m[8]*m[3]*m[14] + m[12]*m[2]*m[11] - m[12]*m[3]*m[10]
+	inv[9] = -m[0]*m[9]*m[15] + m[0]*m[11]*m[13] + m[8]*m[1]*m[15] - m[8]*m[3]*m[13] - m[12]*m[1]*m[11] + m[12]*m[3]*m[9]
+	inv[13] = m[0]*m[9]*m[14] - m[0]*m[10]*m[13] - m[8]*m[1]*m[14] + m[8]*m[2]*m[13] + m[12]*m[1]*m[10] - m[12]*m[2]*m[9]
+	inv[2] = m[1]*m[6]*m[15] - m[1]*m[7]*m[14] - m[5]*m[2]*m[15] + m[5]*m[3]*m[14] + m[13]*m[2]*m[7] - m[13]*m[3]*m[6]
+	inv[6] = -m[0]*m[6]*m[15] + m[0]*m[7]*m[14] + m[4]*m[2]*m[15] - m[4]*m[3]*m[14] - m[12]*m[2]*m[7] + m[12]*m[3]*m[6]
+	inv[10] = m[0]*m[5]*m[15] - m[0]*m[7]*m[13] - m[4]*m[1]*m[15] + m[4]*m[3]*m[13] + m[12]*m[1]*m[7] - m[12]*m[3]*m[5]
+	inv[14] = -m[0]*m[5]*m[14] + m[0]*m[6]*m[13] + m[4]*m[1]*m[14] - m[4]*m[2]*m[13] - m[12]*m[1]*m[6] + m[12]*m[2]*m[5]
+	inv[3] = -m[1]*m[6]*m[11] + m[1]*m[7]*m[10] + m[5]*m[2]*m[11] - m[5]*m[3]*m[10] - m[9]*m[2]*m[7] + m[9]*m[3]*m[6]
+	inv[7] = m[0]*m[6]*m[11] - m[0]*m[7]*m[10] - m[4]*m[2]*m[11] + m[4]*m[3]*m[10] + m[8]*m[2]*m[7] - m[8]*m[3]*m[6]
+	inv[11] = -m[0]*m[5]*m[11] + m[0]*m[7]*m[9] + m[4]*m[1]*m[11] - m[4]*m[3]*m[9] - m[8]*m[1]*m[7] + m[8]*m[3]*m[5]
+	inv[15] = m[0]*m[5]*m[10] - m[0]*m[6]*m[9] - m[4]*m[1]*m[10] + m[4]*m[2]*m[9] + m[8]*m[1]*m[6] - m[8]*m[2]*m[5]
+
+	det := m[0]*inv[0] + m[1]*inv[4] + m[2]*inv[8] + m[3]*inv[12]
+
+	if det == 0 {
+		return mat
+	}
+
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			mat.Data[i][j] = inv[i*4+j] / det
+		}
+	}
+
+	return mat
+}
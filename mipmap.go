@@ -0,0 +1,110 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// mip chains are generated on the CPU when textures are loaded, down to 1x1
+// each texel of a level is the average of the 2x2 texels it covers in the level above, in linear space if the texture is sRGB
+
+var srgb_to_linear [256]float32
+
+func init() {
+	for i := range srgb_to_linear {
+		c := float64(i) / 255
+
+		if c <= 0.04045 {
+			srgb_to_linear[i] = float32(c / 12.92)
+		} else {
+			srgb_to_linear[i] = float32(math.Pow((c+0.055)/1.055, 2.4))
+		}
+	}
+}
+
+func linearToSrgb(c float32) uint8 {
+	var srgb float64
+
+	if c <= 0.0031308 {
+		srgb = float64(c) * 12.92
+	} else {
+		srgb = 1.055*math.Pow(float64(c), 1/2.4) - 0.055
+	}
+
+	return uint8(math.Max(0, math.Min(255, math.Round(srgb*255))))
+}
+
+// every level of the mip chain, starting with the image itself
+
+func GenerateMips(img *image.RGBA, srgb bool) []*image.RGBA {
+	levels := []*image.RGBA{img}
+
+	for {
+		prev := levels[len(levels)-1]
+		prev_width, prev_height := prev.Bounds().Dx(), prev.Bounds().Dy()
+
+		if prev_width <= 1 && prev_height <= 1 {
+			break
+		}
+
+		width, height := prev_width/2, prev_height/2
+
+		if width < 1 {
+			width = 1
+		}
+
+		if height < 1 {
+			height = 1
+		}
+
+		level := image.NewRGBA(image.Rect(0, 0, width, height))
+
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				var sum [4]float32
+				count := float32(0)
+
+				// odd sizes leave a row or column out, and levels with a side of 1 only have one texel to take along it
+
+				for dy := 0; dy < 2; dy++ {
+					for dx := 0; dx < 2; dx++ {
+						sx, sy := x*2+dx, y*2+dy
+
+						if sx >= prev_width {
+							sx = prev_width - 1
+						}
+
+						if sy >= prev_height {
+							sy = prev_height - 1
+						}
+
+						i := prev.PixOffset(prev.Bounds().Min.X+sx, prev.Bounds().Min.Y+sy)
+
+						for k := 0; k < 4; k++ {
+							if srgb && k < 3 {
+								sum[k] += srgb_to_linear[prev.Pix[i+k]]
+							} else {
+								sum[k] += float32(prev.Pix[i+k]) / 255
+							}
+						}
+						count++
+					}
+				}
+
+				i := level.PixOffset(x, y)
+
+				for k := 0; k < 4; k++ {
+					if srgb && k < 3 {
+						level.Pix[i+k] = linearToSrgb(sum[k] / count)
+					} else {
+						level.Pix[i+k] = uint8(math.Round(float64(sum[k] / count * 255)))
+					}
+				}
+			}
+		}
+
+		levels = append(levels, level)
+	}
+
+	return levels
+}
@@ -10,12 +10,6 @@ import (
 	_ "embed"
 )
 
-//go:embed tools/alexis_room.csv
-var coordinates_csv []byte
-
-//go:embed tools/apat.csv
-var apat_csv []byte
-
 type IvxHeader struct {
 	version_major uint64
 	version_minor uint64
@@ -29,11 +23,21 @@ type IvxHeader struct {
 	offset       uint64
 }
 
-type Vertex struct {
+// vertices as they're stored in IVX files
+
+type IvxVertex struct {
 	pos [3]float32
 	uv  [2]float32
 }
 
+// normals are computed when the model is loaded if they're all zero
+
+type Vertex struct {
+	pos    [3]float32
+	uv     [2]float32
+	normal [3]float32
+}
+
 type Heightmap struct {
 	neg_x, neg_z  float32
 	pos_x, pos_z  float32
@@ -48,19 +52,86 @@ type Model struct {
 	ibo         *wgpu.Buffer
 	index_count uint32
 
-	texture    *Texture
-	bind_group *wgpu.BindGroup
-
-	collider_off_x, collider_off_y, collider_off_z float32
+	material *Material
 
 	heightmap *Heightmap
-	colliders []Collider
+
+	// bounds in the model's own space, i.e. in metres
+
+	bounds_min    [3]float32
+	bounds_max    [3]float32
+	bounds_centre [3]float32
+	bounds_radius float32
 }
 
-func NewModel(state *State, label string, vertices []Vertex, indices []uint32, texture []byte, heightmap bool) (*Model, error) {
+func NewModel(state *State, label string, vertices []Vertex, indices []uint32, material string, heightmap bool) (*Model, error) {
 	model := Model{state: state}
 	var err error
 
+	// bounds shit
+
+	for i, vertex := range vertices {
+		for k := 0; k < 3; k++ {
+			if i == 0 || vertex.pos[k] < model.bounds_min[k] {
+				model.bounds_min[k] = vertex.pos[k]
+			}
+
+			if i == 0 || vertex.pos[k] > model.bounds_max[k] {
+				model.bounds_max[k] = vertex.pos[k]
+			}
+		}
+	}
+
+	for k := 0; k < 3; k++ {
+		model.bounds_centre[k] = (model.bounds_min[k] + model.bounds_max[k]) / 2
+	}
+
+	for _, vertex := range vertices {
+		dx := vertex.pos[0] - model.bounds_centre[0]
+		dy := vertex.pos[1] - model.bounds_centre[1]
+		dz := vertex.pos[2] - model.bounds_centre[2]
+
+		if radius := float32(math.Sqrt(float64(dx*dx + dy*dy + dz*dz))); radius > model.bounds_radius {
+			model.bounds_radius = radius
+		}
+	}
+
+	// normal shit
+	// each vertex gets the sum of the normals of the triangles it's in, which weighs them by area
+
+	has_normals := false
+
+	for _, vertex := range vertices {
+		has_normals = has_normals || vertex.normal != [3]float32{}
+	}
+
+	if !has_normals {
+		for i := 0; i+2 < len(indices); i += 3 {
+			a, b, c := &vertices[indices[i]], &vertices[indices[i+1]], &vertices[indices[i+2]]
+
+			ab := [3]float32{b.pos[0] - a.pos[0], b.pos[1] - a.pos[1], b.pos[2] - a.pos[2]}
+			ac := [3]float32{c.pos[0] - a.pos[0], c.pos[1] - a.pos[1], c.pos[2] - a.pos[2]}
+			normal := cross(ab, ac)
+
+			for _, vertex := range []*Vertex{a, b, c} {
+				for k := 0; k < 3; k++ {
+					vertex.normal[k] += normal[k]
+				}
+			}
+		}
+
+		for i := range vertices {
+			normal := &vertices[i].normal
+			length := float32(math.Sqrt(float64(normal[0]*normal[0] + normal[1]*normal[1] + normal[2]*normal[2])))
+
+			if length > 0 {
+				normal[0] /= length
+				normal[1] /= length
+				normal[2] /= length
+			}
+		}
+	}
+
 	// heightmap shit
 
 	if heightmap {
@@ -117,92 +188,31 @@ func NewModel(state *State, label string, vertices []Vertex, indices []uint32, t
 	}
 
 	// vertex buffer shit
+	// buffers and materials come from the resource cache, so models sharing them (e.g. a room and its door sharing a material) share them on the GPU too
 
-	if model.vbo, err = state.device.CreateBufferInit(&wgpu.BufferInitDescriptor{
-		Label:    fmt.Sprintf("VBO (%s)", label),
-		Contents: wgpu.ToBytes(vertices[:]),
-		Usage:    wgpu.BufferUsage_Vertex,
-	}); err != nil {
+	if model.vbo, err = state.resources.Buffer(fmt.Sprintf("VBO (%s)", label), wgpu.ToBytes(vertices[:]), wgpu.BufferUsage_Vertex); err != nil {
 		return nil, err
 	}
 
-	if model.ibo, err = state.device.CreateBufferInit(&wgpu.BufferInitDescriptor{
-		Label:    fmt.Sprintf("IBO (%s)", label),
-		Contents: wgpu.ToBytes(indices[:]),
-		Usage:    wgpu.BufferUsage_Index,
-	}); err != nil {
-		model.vbo.Release()
+	if model.ibo, err = state.resources.Buffer(fmt.Sprintf("IBO (%s)", label), wgpu.ToBytes(indices[:]), wgpu.BufferUsage_Index); err != nil {
+		state.resources.Release(model.vbo)
 		return nil, err
 	}
 
 	model.index_count = uint32(len(indices))
 
-	// texture shit
-
-	if model.texture, err = NewTextureFromBytes(state, label, texture); err != nil {
-		model.vbo.Release()
-		model.ibo.Release()
-		return nil, err
-	}
-
-	// bind group shit
-
-	if model.bind_group, err = state.device.CreateBindGroup(&wgpu.BindGroupDescriptor{
-		Layout: state.regular_pipeline.bind_group_layout,
-		Entries: []wgpu.BindGroupEntry{
-			{
-				Binding:     0,
-				TextureView: model.texture.view,
-			},
-			{
-				Binding: 1,
-				Sampler: model.texture.sampler,
-			},
-			{
-				Binding: 2,
-				Buffer:  state.player.mvp_buf,
-				Size:    wgpu.WholeSize,
-			},
-		},
-	}); err != nil {
-		model.vbo.Release()
-		model.ibo.Release()
-		model.texture.Release()
-		return nil, err
-	}
+	// material shit
 
-	colliders_coords_alexis_room := GetCoordinatesFromCsv(coordinates_csv)
-	for _, coords := range colliders_coords_alexis_room {
-		collider := NewCollider(coords.MeshName, coords.MostNegative, coords.MostPositive)
-		model.colliders = append(model.colliders, *collider)
-	}
-
-	colliders_coords_ukulele := GetCoordinatesFromCsv(apat_csv)
-	for _, coords := range colliders_coords_ukulele {
-		collider := NewCollider(coords.MeshName, coords.MostNegative, coords.MostPositive)
-		model.colliders = append(model.colliders, *collider)
+	if model.material, err = state.resources.Material(material); err != nil {
+		state.resources.Release(model.vbo)
+		state.resources.Release(model.ibo)
+		return nil, fmt.Errorf("%s: %w", label, err)
 	}
 
 	return &model, nil
 }
 
-func (model *Model) ColliderOffset(x, y, z float32) {
-	model.collider_off_x += x
-	model.collider_off_y += y
-	model.collider_off_z += z
-
-	for i := 0; i < len(model.colliders); i++ {
-		model.colliders[i].position1[0] += x
-		model.colliders[i].position1[1] += y
-		model.colliders[i].position1[2] += z
-
-		model.colliders[i].position2[0] += x
-		model.colliders[i].position2[1] += y
-		model.colliders[i].position2[2] += z
-	}
-}
-
-func NewModelFromIvx(state *State, label string, ivx []byte, texture []byte, heightmap bool) (*Model, error) {
+func NewModelFromIvx(state *State, label string, ivx []byte, material string, heightmap bool) (*Model, error) {
 	header := (*IvxHeader)(unsafe.Pointer(&ivx[0]))
 
 	var indices []uint32
@@ -215,21 +225,78 @@ func NewModelFromIvx(state *State, label string, ivx []byte, texture []byte, hei
 	var vertices []Vertex
 
 	for i := uint64(0); i < header.vertex_count; i++ {
-		vertex := (*Vertex)(unsafe.Pointer(&ivx[header.offset+i*uint64(unsafe.Sizeof(vertices[0]))]))
-		vertices = append(vertices, *vertex)
+		vertex := (*IvxVertex)(unsafe.Pointer(&ivx[header.offset+i*uint64(unsafe.Sizeof(IvxVertex{}))]))
+		vertices = append(vertices, Vertex{pos: vertex.pos, uv: vertex.uv})
 	}
 
-	return NewModel(state, label, vertices, indices, texture, heightmap)
+	return NewModel(state, label, vertices, indices, material, heightmap)
 }
 
-func (model *Model) Draw(render_pass *wgpu.RenderPassEncoder) {
-	model.state.regular_pipeline.Set(render_pass, model.bind_group)
+// box from -.5 to .5 along each axis, e.g. for blocks which are placed and sized by instances
+// each face has its own vertices, so it has its own normal and covers the whole of its texture
+
+func NewBoxModel(state *State, label string, material string) (*Model, error) {
+	var vertices []Vertex
+	var indices []uint32
+
+	for axis := 0; axis < 3; axis++ {
+		for _, side := range []float32{-.5, .5} {
+			u, v := (axis+1)%3, (axis+2)%3
+			base := uint32(len(vertices))
+
+			for _, corner := range [4][2]float32{{0, 0}, {1, 0}, {1, 1}, {0, 1}} {
+				var vertex Vertex
+
+				vertex.pos[axis] = side
+				vertex.pos[u] = corner[0] - .5
+				vertex.pos[v] = corner[1] - .5
+				vertex.uv = corner
+				vertex.normal[axis] = side * 2
+
+				vertices = append(vertices, vertex)
+			}
+
+			indices = append(indices, base, base+1, base+2, base, base+2, base+3)
+		}
+	}
+
+	return NewModel(state, label, vertices, indices, material, false)
+}
+
+// camera and transform are offsets in the uniform ring
+// all instances are drawn at once, or just the one if instances is nil
+
+func (model *Model) Draw(render_pass *wgpu.RenderPassEncoder, camera, transform uint32, instances *Instances) {
+	model.material.Set(render_pass)
+	model.state.uniforms.Set(render_pass, camera, transform)
+	model.state.lights.Set(render_pass)
+	model.drawBuffers(render_pass, instances)
+}
+
+// only draw the model's depth, e.g. into a shadow map, in which case camera is the light's view/projection
+
+func (model *Model) DrawDepth(render_pass *wgpu.RenderPassEncoder, camera, transform uint32, instances *Instances) {
+	model.state.shadow_pipeline.Set(render_pass, model.state.shadow_pipeline.empty_bind_group)
+	model.state.uniforms.Set(render_pass, camera, transform)
+	model.drawBuffers(render_pass, instances)
+}
+
+func (model *Model) drawBuffers(render_pass *wgpu.RenderPassEncoder, instances *Instances) {
 	render_pass.SetVertexBuffer(0, model.vbo, 0, wgpu.WholeSize)
 	render_pass.SetIndexBuffer(model.ibo, wgpu.IndexFormat_Uint32, 0, wgpu.WholeSize)
-	render_pass.DrawIndexed(model.index_count, 1, 0, 0, 0)
+
+	if instances == nil {
+		render_pass.SetVertexBuffer(1, model.state.regular_pipeline.default_instance, 0, wgpu.WholeSize)
+		render_pass.DrawIndexed(model.index_count, 1, 0, 0, 0)
+		return
+	}
+
+	render_pass.SetVertexBuffer(1, instances.buffer, 0, wgpu.WholeSize)
+	render_pass.DrawIndexed(model.index_count, uint32(instances.Count()), 0, 0, 0)
 }
 
 func (model *Model) Release() {
-	model.vbo.Release()
-	model.ibo.Release()
+	model.state.resources.Release(model.vbo)
+	model.state.resources.Release(model.ibo)
+	model.state.resources.Release(model.material)
 }
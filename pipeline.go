@@ -7,87 +7,241 @@ import (
 )
 
 type Pipeline struct {
-	shader            *wgpu.ShaderModule
+	resources *ResourceCache
+
+	shader            *Shader // shared between pipelines using the same permutation
 	bind_group_layout *wgpu.BindGroupLayout
 	pipeline_layout   *wgpu.PipelineLayout
 	pipeline          *wgpu.RenderPipeline
 }
 
-func NewPipeline(state *State, label, src string, bind_group_layout_entries []wgpu.BindGroupLayoutEntry, vbo_layouts []wgpu.VertexBufferLayout) (*Pipeline, error) {
-	pipeline := &Pipeline{}
-	var err error
+// the scene is drawn in HDR, and only turned into something the swapchain can show by post-processing
+
+const HDR_FORMAT = wgpu.TextureFormat_RGBA16Float
+
+// bind group layout shared between pipelines, e.g. the uniform ring's
+// the entries it was made from are kept, to check the shaders of the pipelines using it against them
+
+type SharedLayout struct {
+	layout  *wgpu.BindGroupLayout
+	entries []wgpu.BindGroupLayoutEntry
+}
 
-	if pipeline.shader, err = state.device.CreateShaderModule(&wgpu.ShaderModuleDescriptor{
-		Label: fmt.Sprintf("Shader module (%s)", label),
-		WGSLDescriptor: &wgpu.ShaderModuleWGSLDescriptor{
-			Code: src,
+func NewSharedLayout(state *State, label string, entries []wgpu.BindGroupLayoutEntry) (*SharedLayout, error) {
+	layout, err := state.device.CreateBindGroupLayout(&wgpu.BindGroupLayoutDescriptor{
+		Label:   label,
+		Entries: entries,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SharedLayout{
+		layout:  layout,
+		entries: entries,
+	}, nil
+}
+
+func (layout *SharedLayout) Release() {
+	layout.layout.Release()
+}
+
+// how colours being drawn are combined with what's already in the colour attachment
+
+type BlendMode int
+
+const (
+	BLEND_REPLACE BlendMode = iota
+	BLEND_ALPHA
+	BLEND_PREMULTIPLIED
+	BLEND_ADDITIVE
+)
+
+var BLEND_STATES = map[BlendMode]wgpu.BlendState{
+	BLEND_REPLACE:       wgpu.BlendState_Replace,
+	BLEND_ALPHA:         wgpu.BlendState_AlphaBlending,
+	BLEND_PREMULTIPLIED: wgpu.BlendState_PremultipliedAlphaBlending,
+	BLEND_ADDITIVE: {
+		Color: wgpu.BlendComponent{
+			SrcFactor: wgpu.BlendFactor_One,
+			DstFactor: wgpu.BlendFactor_One,
+			Operation: wgpu.BlendOperation_Add,
 		},
-	}); err != nil {
+		Alpha: wgpu.BlendComponent_Over,
+	},
+}
+
+// everything a pipeline is made from
+// pipelines draw into attachments of the given colour format, and with a depth attachment if depth is set
+// with an undefined colour format, the pipeline has no fragment stage and only writes depth, e.g. for shadow maps
+// the shader is the path of its source in shaders/, preprocessed with the given defines
+// group 0's layout is made from what the shader declares in it, and shared layouts are the following groups
+// line topologies, e.g. for wireframes, need index buffers of lines rather than of triangles
+
+type PipelineDescriptor struct {
+	label   string
+	shader  string
+	defines ShaderDefines
+
+	format wgpu.TextureFormat
+	blend  BlendMode
+
+	topology  wgpu.PrimitiveTopology
+	cull_mode wgpu.CullMode
+
+	depth         bool
+	depth_write   bool
+	depth_compare wgpu.CompareFunction
+
+	vbo_layouts    []wgpu.VertexBufferLayout
+	shared_layouts []*SharedLayout
+}
+
+// what every pipeline used to be, i.e. opaque triangles, depth tested and written, without culling as models are double-sided
+// copy it and set what's different, at least the label, shader and format
+
+var DEFAULT_PIPELINE_DESCRIPTOR = PipelineDescriptor{
+	blend:         BLEND_REPLACE,
+	topology:      wgpu.PrimitiveTopology_TriangleList,
+	cull_mode:     wgpu.CullMode_None,
+	depth:         true,
+	depth_write:   true,
+	depth_compare: wgpu.CompareFunction_Less,
+}
+
+// pipelines with the same descriptor are the same pipeline, so the label is left out
+// shared layouts are told apart by identity
+
+func (descriptor *PipelineDescriptor) Key() string {
+	return fmt.Sprintf("%s|%s|%v|%v|%v|%v|%v|%v|%v|%v|%v",
+		descriptor.shader, descriptor.defines.Key(),
+		descriptor.format, descriptor.blend,
+		descriptor.topology, descriptor.cull_mode,
+		descriptor.depth, descriptor.depth_write, descriptor.depth_compare,
+		descriptor.vbo_layouts, descriptor.shared_layouts)
+}
+
+// the shader is checked against the shared layouts and the vertex buffers, so mismatches are caught when the pipeline is made
+// pipelines are usually asked for through the resource cache instead, so they're shared
+
+func NewPipeline(state *State, descriptor PipelineDescriptor) (*Pipeline, error) {
+	pipeline := &Pipeline{resources: state.resources}
+	label := descriptor.label
+	var err error
+
+	if pipeline.shader, err = state.resources.Shader(descriptor.shader, descriptor.defines); err != nil {
 		return nil, err
 	}
 
+	// reflection errors are at lines of the preprocessed source, which are pointed back at the original file
+
+	if err := pipeline.check(descriptor.vbo_layouts, descriptor.shared_layouts); err != nil {
+		pipeline.resources.Release(pipeline.shader)
+		return nil, fmt.Errorf("%s: %w", descriptor.shader, pipeline.shader.source.MapError(err))
+	}
+
+	stages := wgpu.ShaderStage_Vertex
+
+	if descriptor.format != wgpu.TextureFormat_Undefined {
+		stages |= wgpu.ShaderStage_Fragment
+	}
+
+	bind_group_layout_entries, err := pipeline.shader.reflection.Layout(0, stages)
+	if err != nil {
+		pipeline.resources.Release(pipeline.shader)
+		return nil, fmt.Errorf("%s: %w", descriptor.shader, pipeline.shader.source.MapError(err))
+	}
+
 	if pipeline.bind_group_layout, err = state.device.CreateBindGroupLayout(&wgpu.BindGroupLayoutDescriptor{
 		Label:   fmt.Sprintf("Bind group layout (%s)", label),
 		Entries: bind_group_layout_entries,
 	}); err != nil {
-		pipeline.shader.Release()
+		pipeline.resources.Release(pipeline.shader)
 		return nil, err
 	}
 
+	bind_group_layouts := []*wgpu.BindGroupLayout{pipeline.bind_group_layout}
+
+	for _, shared := range descriptor.shared_layouts {
+		bind_group_layouts = append(bind_group_layouts, shared.layout)
+	}
+
 	if pipeline.pipeline_layout, err = state.device.CreatePipelineLayout(&wgpu.PipelineLayoutDescriptor{
-		Label: fmt.Sprintf("Pipeline layout (%s)", label),
-		BindGroupLayouts: []*wgpu.BindGroupLayout{
-			pipeline.bind_group_layout,
-		},
+		Label:            fmt.Sprintf("Pipeline layout (%s)", label),
+		BindGroupLayouts: bind_group_layouts,
 	}); err != nil {
-		pipeline.shader.Release()
+		pipeline.resources.Release(pipeline.shader)
 		pipeline.bind_group_layout.Release()
 		return nil, err
 	}
 
-	if pipeline.pipeline, err = state.device.CreateRenderPipeline(&wgpu.RenderPipelineDescriptor{
-		Label:  fmt.Sprintf("Render pipeline (%s)", label),
-		Layout: pipeline.pipeline_layout,
-		Primitive: wgpu.PrimitiveState{
-			Topology:         wgpu.PrimitiveTopology_TriangleList,
-			StripIndexFormat: wgpu.IndexFormat_Undefined,
-			FrontFace:        wgpu.FrontFace_CCW,
-			CullMode:         wgpu.CullMode_None,
-		},
-		Vertex: wgpu.VertexState{
-			Module:     pipeline.shader,
-			EntryPoint: "vert_main",
-			Buffers:    vbo_layouts,
-		},
-		Fragment: &wgpu.FragmentState{
-			Module:     pipeline.shader,
-			EntryPoint: "frag_main",
+	var fragment *wgpu.FragmentState
+
+	if descriptor.format != wgpu.TextureFormat_Undefined {
+		blend, ok := BLEND_STATES[descriptor.blend]
+
+		if !ok {
+			pipeline.resources.Release(pipeline.shader)
+			pipeline.bind_group_layout.Release()
+			pipeline.pipeline_layout.Release()
+			return nil, fmt.Errorf("unknown blend mode %d", descriptor.blend)
+		}
+
+		fragment = &wgpu.FragmentState{
+			Module:     pipeline.shader.module,
+			EntryPoint: FRAGMENT_ENTRY_POINT,
 			Targets: []wgpu.ColorTargetState{
 				{
-					Format:    state.config.Format,
-					Blend:     &wgpu.BlendState_Replace,
+					Format:    descriptor.format,
+					Blend:     &blend,
 					WriteMask: wgpu.ColorWriteMask_All,
 				},
 			},
-		},
-		DepthStencil: &wgpu.DepthStencilState{
+		}
+	}
+
+	var depth_stencil *wgpu.DepthStencilState
+
+	if descriptor.depth {
+		depth_stencil = &wgpu.DepthStencilState{
 			Format:            DEPTH_FORMAT,
-			DepthWriteEnabled: true,
-			DepthCompare:      wgpu.CompareFunction_Less,
+			DepthWriteEnabled: descriptor.depth_write,
+			DepthCompare:      descriptor.depth_compare,
 			StencilFront: wgpu.StencilFaceState{
 				Compare: wgpu.CompareFunction_Always,
 			},
 			StencilBack: wgpu.StencilFaceState{
 				Compare: wgpu.CompareFunction_Always,
 			},
+		}
+	}
+
+	// strips need to know the index format to restart them, but nothing draws strips with indices yet
+
+	if pipeline.pipeline, err = state.device.CreateRenderPipeline(&wgpu.RenderPipelineDescriptor{
+		Label:  fmt.Sprintf("Render pipeline (%s)", label),
+		Layout: pipeline.pipeline_layout,
+		Primitive: wgpu.PrimitiveState{
+			Topology:         descriptor.topology,
+			StripIndexFormat: wgpu.IndexFormat_Undefined,
+			FrontFace:        wgpu.FrontFace_CCW,
+			CullMode:         descriptor.cull_mode,
+		},
+		Vertex: wgpu.VertexState{
+			Module:     pipeline.shader.module,
+			EntryPoint: VERTEX_ENTRY_POINT,
+			Buffers:    descriptor.vbo_layouts,
 		},
+		Fragment:     fragment,
+		DepthStencil: depth_stencil,
 		Multisample: wgpu.MultisampleState{
-			Count:                  1,
+			Count:                  1, // none of the attachments are multisampled
 			Mask:                   0xFFFFFFFF,
 			AlphaToCoverageEnabled: false,
 		},
 	}); err != nil {
-		pipeline.shader.Release()
+		pipeline.resources.Release(pipeline.shader)
 		pipeline.bind_group_layout.Release()
 		pipeline.pipeline_layout.Release()
 		return nil, err
@@ -96,13 +250,31 @@ func NewPipeline(state *State, label, src string, bind_group_layout_entries []wg
 	return pipeline, nil
 }
 
+func (pipeline *Pipeline) check(vbo_layouts []wgpu.VertexBufferLayout, shared_layouts []*SharedLayout) error {
+	reflection := pipeline.shader.reflection
+
+	for _, group := range reflection.Groups() {
+		if int(group) > len(shared_layouts) {
+			return fmt.Errorf("nothing is bound to group %d", group)
+		}
+	}
+
+	for i, shared := range shared_layouts {
+		if err := reflection.CheckLayout(uint32(i+1), shared.entries); err != nil {
+			return err
+		}
+	}
+
+	return reflection.CheckVertexBuffers(vbo_layouts)
+}
+
 func (pipeline *Pipeline) Set(render_pass *wgpu.RenderPassEncoder, bind_group *wgpu.BindGroup) {
 	render_pass.SetPipeline(pipeline.pipeline)
 	render_pass.SetBindGroup(0, bind_group, nil)
 }
 
 func (pipeline *Pipeline) Release() {
-	pipeline.shader.Release()
+	pipeline.resources.Release(pipeline.shader)
 	pipeline.bind_group_layout.Release()
 	pipeline.pipeline_layout.Release()
 	pipeline.pipeline.Release()
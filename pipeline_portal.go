@@ -0,0 +1,31 @@
+package main
+
+import "github.com/rajveermalviya/go-webgpu/wgpu"
+
+type PortalPipeline struct {
+	*Pipeline
+}
+
+func NewPortalPipeline(state *State) (*PortalPipeline, error) {
+	descriptor := DEFAULT_PIPELINE_DESCRIPTOR
+	descriptor.label = "Portal"
+	descriptor.shader = "portal.wgsl"
+	descriptor.format = HDR_FORMAT
+	descriptor.vbo_layouts = []wgpu.VertexBufferLayout{
+		state.regular_pipeline.vbo_layout,
+	}
+
+	pipeline, err := state.resources.Pipeline(descriptor)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &PortalPipeline{
+		Pipeline: pipeline,
+	}, nil
+}
+
+func (pipeline *PortalPipeline) Release() {
+	pipeline.resources.Release(pipeline.Pipeline)
+}
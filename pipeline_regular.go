@@ -1,19 +1,22 @@
 package main
 
 import (
-	_ "embed"
 	"unsafe"
 
 	"github.com/rajveermalviya/go-webgpu/wgpu"
 )
 
+// what models are drawn with, i.e. their vertex layout and the pipeline state materials start from
+// the pipelines themselves are made per material, see material.go
+
 type RegularPipeline struct {
-	Pipeline
 	vbo_layout wgpu.VertexBufferLayout
-}
+	descriptor PipelineDescriptor
 
-//go:embed shaders/regular.wgsl
-var regular_shader_src string
+	// models which aren't instanced are drawn as a single untransformed, untinted instance
+
+	default_instance *wgpu.Buffer
+}
 
 func NewRegularPipeline(state *State) (*RegularPipeline, error) {
 	vbo_layout := wgpu.VertexBufferLayout{
@@ -30,50 +33,44 @@ func NewRegularPipeline(state *State) (*RegularPipeline, error) {
 				Offset:         4 * 3,
 				ShaderLocation: 1,
 			},
+			{ // after the instance attributes
+				Format:         wgpu.VertexFormat_Float32x3,
+				Offset:         4 * 5,
+				ShaderLocation: 7,
+			},
 		},
 	}
 
-	pipeline, err := NewPipeline(state, "Regular", regular_shader_src,
-		[]wgpu.BindGroupLayoutEntry{
-			{ // texture
-				Binding:    0,
-				Visibility: wgpu.ShaderStage_Fragment,
-				Texture: wgpu.TextureBindingLayout{
-					Multisampled:  false,
-					ViewDimension: wgpu.TextureViewDimension_2D,
-					SampleType:    wgpu.TextureSampleType_Float,
-				},
-			},
-			{ // sampler
-				Binding:    1,
-				Visibility: wgpu.ShaderStage_Fragment,
-				Sampler: wgpu.SamplerBindingLayout{
-					Type: wgpu.SamplerBindingType_Filtering,
-				},
-			},
-			{ // MVP matrix
-				Binding:    2,
-				Visibility: wgpu.ShaderStage_Vertex,
-				Buffer: wgpu.BufferBindingLayout{
-					Type: wgpu.BufferBindingType_Uniform,
-				},
-			},
-		},
-		[]wgpu.VertexBufferLayout{
-			vbo_layout,
-		},
-	)
+	descriptor := DEFAULT_PIPELINE_DESCRIPTOR
+	descriptor.label = "Regular"
+	descriptor.shader = "regular.wgsl"
+	descriptor.format = HDR_FORMAT
+	descriptor.vbo_layouts = []wgpu.VertexBufferLayout{
+		vbo_layout,
+		INSTANCE_VBO_LAYOUT,
+	}
+	descriptor.shared_layouts = []*SharedLayout{
+		state.uniforms.bind_group_layout, // camera and model matrices, in group 1
+		state.lights.bind_group_layout,   // dynamic lights, in group 2
+	}
+
+	default_instance, err := state.device.CreateBufferInit(&wgpu.BufferInitDescriptor{
+		Label:    "Default instance",
+		Contents: wgpu.ToBytes([]InstanceData{INSTANCE_IDENTITY}),
+		Usage:    wgpu.BufferUsage_Vertex,
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
 	return &RegularPipeline{
-		Pipeline:   *pipeline,
-		vbo_layout: vbo_layout,
+		vbo_layout:       vbo_layout,
+		descriptor:       descriptor,
+		default_instance: default_instance,
 	}, nil
 }
 
 func (pipeline *RegularPipeline) Release() {
-	pipeline.Pipeline.Release()
+	pipeline.default_instance.Release()
 }
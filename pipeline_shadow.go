@@ -0,0 +1,54 @@
+package main
+
+import "github.com/rajveermalviya/go-webgpu/wgpu"
+
+// depth-only pipeline drawing models into shadow maps, from a light's point of view
+
+type ShadowPipeline struct {
+	*Pipeline
+
+	// there's nothing in group 0, but it still needs a bind group
+
+	empty_bind_group *wgpu.BindGroup
+}
+
+func NewShadowPipeline(state *State) (*ShadowPipeline, error) {
+	descriptor := DEFAULT_PIPELINE_DESCRIPTOR
+	descriptor.label = "Shadow"
+	descriptor.shader = "shadow.wgsl"
+	descriptor.format = wgpu.TextureFormat_Undefined
+	descriptor.vbo_layouts = []wgpu.VertexBufferLayout{
+		state.regular_pipeline.vbo_layout,
+		INSTANCE_VBO_LAYOUT,
+	}
+	descriptor.shared_layouts = []*SharedLayout{
+		state.uniforms.bind_group_layout, // light's view/projection and model matrices, in group 1
+	}
+
+	pipeline, err := state.resources.Pipeline(descriptor)
+
+	if err != nil {
+		return nil, err
+	}
+
+	empty_bind_group, err := state.device.CreateBindGroup(&wgpu.BindGroupDescriptor{
+		Label:   "Bind group (shadow)",
+		Layout:  pipeline.bind_group_layout,
+		Entries: []wgpu.BindGroupEntry{},
+	})
+
+	if err != nil {
+		state.resources.Release(pipeline)
+		return nil, err
+	}
+
+	return &ShadowPipeline{
+		Pipeline:         pipeline,
+		empty_bind_group: empty_bind_group,
+	}, nil
+}
+
+func (pipeline *ShadowPipeline) Release() {
+	pipeline.empty_bind_group.Release()
+	pipeline.resources.Release(pipeline.Pipeline)
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"log"
 	"math"
 
 	"github.com/go-gl/glfw/v3.3/glfw"
-	"github.com/rajveermalviya/go-webgpu/wgpu"
 )
 
+const PLAYER_FOV = math.Pi / 2
+const PLAYER_NEAR = 0.01
+const PLAYER_FAR = 50
+
 type Player struct {
 	Entity
 	state *State
@@ -14,21 +18,14 @@ type Player struct {
 	p *Mat
 	v *Mat
 
-	mvp_buf *wgpu.Buffer
+	// when rendering what's seen through a portal, the camera is moved behind the other portal
+	// everything between it and that portal is clipped away
 
-	roll float32
+	view_override *Mat
+	clip_plane    *[4]float32
 }
 
-func NewPlayer(state *State) (*Player, error) {
-	mvp_buf, err := state.device.CreateBuffer(&wgpu.BufferDescriptor{
-		Size:  64,
-		Usage: wgpu.BufferUsage_Uniform | wgpu.BufferUsage_CopyDst,
-	})
-
-	if err != nil {
-		return nil, err
-	}
-
+func NewPlayer(state *State) *Player {
 	position := [3]float32{0, 0, 0}
 	rotation := [2]float32{math.Pi / 2, 0}
 
@@ -38,9 +35,7 @@ func NewPlayer(state *State) (*Player, error) {
 
 		p: NewMat().Identity(),
 		v: NewMat().Identity(),
-
-		mvp_buf: mvp_buf,
-	}, nil
+	}
 }
 
 func (player *Player) HandleInputs() {
@@ -72,8 +67,12 @@ func (player *Player) HandleInputs() {
 
 	if input[1] != 0 || input[0] != 0 {
 		angle := player.rot[0] - math.Pi/2 + float32(math.Atan2(float64(input[1]), float64(input[0])))
-		player.acc[0] = float32(math.Cos(float64(angle))) * speed
-		player.acc[2] = float32(math.Sin(float64(angle))) * speed
+
+		player.acc = player.orientation.TransformVector([3]float32{
+			float32(math.Cos(float64(angle))) * speed,
+			0,
+			float32(math.Sin(float64(angle))) * speed,
+		})
 	}
 }
 
@@ -96,59 +95,86 @@ func (player *Player) HandleMouse() {
 	player.state.win.SetInputMode(glfw.CursorMode, glfw.CursorDisabled)
 }
 
-func (player *Player) Release() {
-	player.mvp_buf.Release()
-}
-
 const M_TO_AYLIN = 1 / 1.64
-var doneuk1 = false
 
-func (player *Player) mvp(m *Mat) *Mat {
-	width, height := player.state.win.GetSize()
-	eyelevel := float32(1) // exactly one Aylin
+// view matrix of the player's own camera, regardless of what's being rendered
+// yaw and pitch are relative to the player's orientation, so going through a portal doesn't make the camera jump
 
-	player.p.Perspective(math.Pi/2, float32(width)/float32(height), 0.01, 50)
+func (player *Player) View() *Mat {
+	eyelevel := float32(1) // exactly one Aylin
+	up := player.Up()
 
-	player.v.Identity()
-	player.v.Multiply(NewMat().Rotate2d((player.rot[0] - math.Pi/2), player.rot[1]))
-	player.v.Multiply(NewMat().Translation(-player.pos[0], -player.pos[1]-eyelevel, -player.pos[2]))
+	// orientation is a rotation, so its inverse is its transpose
 
-	target_roll := 0.
+	orientation := NewMat()
 
-	if player.Entity.pos[1] < -1 && !player.state.apat.portal_lit {
-		if !doneuk1 {
-			displayDialogue(getDialogues(), "ukulele1", player.state)
-			doneuk1 = true
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			orientation.Data[i][j] = player.orientation.Data[j][i]
 		}
-		player.state.alexis_room.should_draw = false
-		target_roll = math.Pi
 	}
 
-	player.roll += (float32(target_roll) - player.roll) * player.state.dt * 1
+	view := NewMat().Rotate2d((player.rot[0] - math.Pi/2), player.rot[1])
+	view.Multiply(orientation)
+	view.Multiply(NewMat().Translation(-player.pos[0]-up[0]*eyelevel, -player.pos[1]-up[1]*eyelevel, -player.pos[2]-up[2]*eyelevel))
+
+	return view
+}
+
+// projection of the player's camera, for the current size of what's rendered to
+// this is needed before anything is rendered, e.g. to fit shadow cascades around the player's view
 
-	roll_mat := NewMat().Rotate(player.roll, 0, 0, 1)
-	aylin_conversion_mat := NewMat().Scale(M_TO_AYLIN, M_TO_AYLIN, M_TO_AYLIN)
+func (player *Player) Projection() *Mat {
+	width, height := player.state.Size()
+	return player.p.Perspective(PLAYER_FOV, float32(width)/float32(height), PLAYER_NEAR, PLAYER_FAR)
+}
+
+// projection and view of whatever camera is being rendered from
+
+func (player *Player) ViewProjection() *Mat {
+	player.Projection()
+
+	if player.view_override != nil {
+		player.v.Data = player.view_override.Data
+	} else {
+		player.v.Data = player.View().Data
+	}
+
+	p := player.p
 
-	if player.state.obama_room.should_draw {
-		player.pos[0] = -2
-		player.pos[1] = 0
-		player.pos[2] = 0
+	if player.clip_plane != nil {
+		p = ObliqueProjection(p, TransformPlane(player.v, *player.clip_plane))
 	}
 
-	mvp := NewMat().Multiply(player.p).Multiply(roll_mat).Multiply(player.v).Multiply(m).Multiply(aylin_conversion_mat)
-	player.state.queue.WriteBuffer(player.mvp_buf, 0, wgpu.ToBytes(mvp.Data[:]))
+	return NewMat().Multiply(p).Multiply(player.v)
+}
+
+// models are in metres, but the world is in Aylins
 
-	return mvp
+func ModelMatrix(m *Mat) *Mat {
+	return NewMat().Multiply(m).Multiply(NewMat().Scale(M_TO_AYLIN, M_TO_AYLIN, M_TO_AYLIN))
+}
+
+func (player *Player) mvp(m *Mat) *Mat {
+	return player.ViewProjection().Multiply(ModelMatrix(m))
 }
 
 func (player *Player) Update() {
-	player.HandleInputs()
-	player.HandleMouse()
+	// cutscenes drive the camera themselves
+
+	if !player.state.timeline.Playing() {
+		player.HandleInputs()
+		player.HandleMouse()
 
-	player.Entity.Update([]*Model{
-		player.state.alexis_room.room,
-		player.state.apat.landscape,
-	})
+		player.Entity.Update(player.state.worlds.CollisionNodes())
+
+		// the player is always in the world it's come out into
+
+		if player.traversed != nil {
+			log.Printf("Go through portal into world %q\n", player.traversed.world)
+			player.state.worlds.Transition(player.traversed.world, 0)
+		}
+	}
 
 	if player.state.win.GetKey(glfw.KeyEscape) == glfw.Press {
 		println("Escape pressed -> Close window")
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"unsafe"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// how many portals deep we can see, e.g. a portal seen through another portal needs a depth of 2
+
+const DEFAULT_PORTAL_DEPTH = 2
+
+// turns a portal around, so that looking into one side of a portal looks out of the back of the other
+
+var PORTAL_FLIP = NewMat().Scale(-1, 1, -1)
+
+// portals are boxes reaching one metre behind their quad, which are flattened unless the camera is right in front of them (see Thickness)
+
+var PORTAL_INDICES = []uint32{
+	0, 1, 2, 0, 2, 3, // front
+	5, 4, 7, 5, 7, 6, // back
+	4, 0, 3, 4, 3, 7, // left
+	1, 5, 6, 1, 6, 2, // right
+	3, 2, 6, 3, 6, 7, // top
+	4, 5, 1, 4, 1, 0, // bottom
+}
+
+type PortalUniforms struct {
+	mvp    [4][4]float32
+	screen [2]float32
+	_      [2]float32
+}
+
+// what's seen through a portal at a given recursion level
+
+type PortalTarget struct {
+	colour     *Texture
+	depth      *Texture
+	bind_group *wgpu.BindGroup
+}
+
+// a portal is a quad in the XY plane of its scene node, seen from its +Z side
+// it shows what its destination portal sees out of its own +Z side
+
+type Portal struct {
+	state *State
+	name  string
+	world string
+	node  *SceneNode
+
+	destination string
+
+	width  float32
+	height float32
+
+	vbo         *wgpu.Buffer
+	ibo         *wgpu.Buffer
+	uniform_buf *wgpu.Buffer
+
+	targets       []*PortalTarget
+	target_width  uint32
+	target_height uint32
+}
+
+// width and height are in metres, like the models
+// the node is in world units, also like the models
+
+func NewPortal(state *State, name, world string, node *SceneNode, width, height float32, destination string) (*Portal, error) {
+	portal := &Portal{
+		state:       state,
+		name:        name,
+		world:       world,
+		node:        node,
+		destination: destination,
+		width:       width,
+		height:      height,
+	}
+
+	w, h := width/2, height/2
+
+	vertices := []Vertex{
+		{pos: [3]float32{-w, -h, 0}, uv: [2]float32{0, 0}},
+		{pos: [3]float32{w, -h, 0}, uv: [2]float32{1, 0}},
+		{pos: [3]float32{w, h, 0}, uv: [2]float32{1, 1}},
+		{pos: [3]float32{-w, h, 0}, uv: [2]float32{0, 1}},
+		{pos: [3]float32{-w, -h, -1}, uv: [2]float32{0, 0}},
+		{pos: [3]float32{w, -h, -1}, uv: [2]float32{1, 0}},
+		{pos: [3]float32{w, h, -1}, uv: [2]float32{1, 1}},
+		{pos: [3]float32{-w, h, -1}, uv: [2]float32{0, 1}},
+	}
+
+	var err error
+
+	if portal.vbo, err = state.device.CreateBufferInit(&wgpu.BufferInitDescriptor{
+		Label:    fmt.Sprintf("VBO (%s portal)", name),
+		Contents: wgpu.ToBytes(vertices[:]),
+		Usage:    wgpu.BufferUsage_Vertex,
+	}); err != nil {
+		return nil, err
+	}
+
+	if portal.ibo, err = state.device.CreateBufferInit(&wgpu.BufferInitDescriptor{
+		Label:    fmt.Sprintf("IBO (%s portal)", name),
+		Contents: wgpu.ToBytes(PORTAL_INDICES),
+		Usage:    wgpu.BufferUsage_Index,
+	}); err != nil {
+		portal.vbo.Release()
+		return nil, err
+	}
+
+	if portal.uniform_buf, err = state.device.CreateBuffer(&wgpu.BufferDescriptor{
+		Label: fmt.Sprintf("Uniforms (%s portal)", name),
+		Size:  uint64(unsafe.Sizeof(PortalUniforms{})),
+		Usage: wgpu.BufferUsage_Uniform | wgpu.BufferUsage_CopyDst,
+	}); err != nil {
+		portal.vbo.Release()
+		portal.ibo.Release()
+		return nil, err
+	}
+
+	state.portals.Add(portal)
+	return portal, nil
+}
+
+// basis for a portal's scene node: its centre, the direction it faces, and which way is up
+
+func NewPortalMat(centre, normal, up [3]float32) *Mat {
+	right := cross(up, normal)
+
+	return &Mat{Data: [4][4]float32{
+		{right[0], right[1], right[2], 0},
+		{up[0], up[1], up[2], 0},
+		{normal[0], normal[1], normal[2], 0},
+		{centre[0], centre[1], centre[2], 1},
+	}}
+}
+
+func cross(a, b [3]float32) [3]float32 {
+	return [3]float32{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+func dot4(a, b [4]float32) float32 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] + a[3]*b[3]
+}
+
+// transform taking things from one side of a portal out of the other side of another
+// something going into the first portal's front comes out of the second portal's front
+
+func PortalTransform(from, to *SceneNode) *Mat {
+	return NewMat().Multiply(to.World()).Multiply(PORTAL_FLIP).Multiply(from.World().Copy().Inverse())
+}
+
+// plane of a portal, as (normal, -distance), positive on the side it faces
+
+func (portal *Portal) Plane() [4]float32 {
+	world := portal.node.World()
+
+	normal := world.TransformVector([3]float32{0, 0, 1})
+	centre := world.TransformPoint([3]float32{0, 0, 0})
+
+	return [4]float32{normal[0], normal[1], normal[2], -(normal[0]*centre[0] + normal[1]*centre[1] + normal[2]*centre[2])}
+}
+
+// replace the near plane of a projection by an arbitrary plane in view space
+// this is Lengyel's oblique near-plane clipping, so that nothing between the camera and a portal is drawn through it
+// WebGPU clips z to [0, 1], so the third row of the projection is replaced outright
+
+func ObliqueProjection(proj *Mat, plane [4]float32) *Mat {
+	sign := func(x float32) float32 {
+		if x > 0 {
+			return 1
+		}
+
+		if x < 0 {
+			return -1
+		}
+
+		return 0
+	}
+
+	// far corner of the frustum opposite the plane
+
+	inv := proj.Copy().Inverse()
+	corner := [4]float32{sign(plane[0]), sign(plane[1]), 1, 1}
+	var q [4]float32
+
+	for j := 0; j < 4; j++ {
+		q[j] = inv.Data[0][j]*corner[0] + inv.Data[1][j]*corner[1] + inv.Data[2][j]*corner[2] + inv.Data[3][j]*corner[3]
+	}
+
+	scale := 1 / dot4(plane, q)
+	res := proj.Copy()
+
+	for i := 0; i < 4; i++ {
+		res.Data[i][2] = plane[i] * scale
+	}
+
+	return res
+}
+
+// how far the near plane of a projection reaches from the camera, i.e. how far its corners are
+
+func nearPlaneReach(proj *Mat) float32 {
+	inv := proj.Copy().Inverse()
+	reach := float32(0)
+
+	for _, x := range []float32{-1, 1} {
+		for _, y := range []float32{-1, 1} {
+			var corner [4]float32
+
+			for j := range corner {
+				corner[j] = inv.Data[0][j]*x + inv.Data[1][j]*y + inv.Data[3][j]
+			}
+
+			length := float32(math.Sqrt(float64(corner[0]*corner[0]+corner[1]*corner[1]+corner[2]*corner[2]))) / corner[3]
+
+			if length > reach {
+				reach = length
+			}
+		}
+	}
+
+	return reach
+}
+
+// planes transform by the inverse transpose
+
+func TransformPlane(mat *Mat, plane [4]float32) [4]float32 {
+	inv := mat.Copy().Inverse()
+	var res [4]float32
+
+	for i := 0; i < 4; i++ {
+		res[i] = inv.Data[i][0]*plane[0] + inv.Data[i][1]*plane[1] + inv.Data[i][2]*plane[2] + inv.Data[i][3]*plane[3]
+	}
+
+	return res
+}
+
+// did something moving between two points go through the portal from its front to its back?
+
+func (portal *Portal) Crossed(from, to [3]float32) bool {
+	plane := portal.Plane()
+
+	from_dist := dot4(plane, [4]float32{from[0], from[1], from[2], 1})
+	to_dist := dot4(plane, [4]float32{to[0], to[1], to[2], 1})
+
+	if from_dist < 0 || to_dist >= 0 {
+		return false
+	}
+
+	// where the segment meets the plane, in the portal's space
+
+	t := from_dist / (from_dist - to_dist)
+	point := [3]float32{}
+
+	for i := range point {
+		point[i] = from[i] + (to[i]-from[i])*t
+	}
+
+	local := portal.node.World().Copy().Inverse().TransformPoint(point)
+
+	w, h := portal.width*M_TO_AYLIN/2, portal.height*M_TO_AYLIN/2
+	return local[0] >= -w && local[0] <= w && local[1] >= -h && local[1] <= h
+}
+
+func (portal *Portal) ensureTargets(depth int, width, height uint32) error {
+	if portal.target_width != width || portal.target_height != height {
+		portal.releaseTargets()
+	}
+
+	portal.target_width = width
+	portal.target_height = height
+
+	for len(portal.targets) < depth {
+		level := len(portal.targets) + 1
+		target := &PortalTarget{}
+		var err error
+
+		if target.colour, err = NewRenderTexture(portal.state, fmt.Sprintf("%s portal colour (level %d)", portal.name, level), HDR_FORMAT, width, height); err != nil {
+			return err
+		}
+
+		if target.depth, err = NewRenderTexture(portal.state, fmt.Sprintf("%s portal depth (level %d)", portal.name, level), DEPTH_FORMAT, width, height); err != nil {
+			target.colour.Release()
+			return err
+		}
+
+		if target.bind_group, err = portal.state.device.CreateBindGroup(&wgpu.BindGroupDescriptor{
+			Layout: portal.state.portal_pipeline.bind_group_layout,
+			Entries: []wgpu.BindGroupEntry{
+				{
+					Binding:     0,
+					TextureView: target.colour.view,
+				},
+				{
+					Binding: 1,
+					Sampler: target.colour.sampler,
+				},
+				{
+					Binding: 2,
+					Buffer:  portal.uniform_buf,
+					Size:    wgpu.WholeSize,
+				},
+			},
+		}); err != nil {
+			target.colour.Release()
+			target.depth.Release()
+			return err
+		}
+
+		portal.targets = append(portal.targets, target)
+	}
+
+	return nil
+}
+
+func (portal *Portal) releaseTargets() {
+	for _, target := range portal.targets {
+		target.bind_group.Release()
+		target.colour.Release()
+		target.depth.Release()
+	}
+
+	portal.targets = nil
+}
+
+// the camera's near plane goes through a portal just before the camera does, which would clip its quad and show what's behind it for a frame or so
+// so when the camera is that close in front of a portal, the portal is drawn as a box reaching behind it, past the near plane
+// both the reach of the near plane and the thickness are in world units
+
+func (portal *Portal) Thickness(eye [3]float32, reach float32) float32 {
+	dist := dot4(portal.Plane(), [4]float32{eye[0], eye[1], eye[2], 1})
+
+	if dist < 0 || dist > reach {
+		return 0
+	}
+
+	local := portal.node.World().Copy().Inverse().TransformPoint(eye)
+	w, h := portal.width*M_TO_AYLIN/2+reach, portal.height*M_TO_AYLIN/2+reach
+
+	if local[0] < -w || local[0] > w || local[1] < -h || local[1] > h {
+		return 0
+	}
+
+	return reach
+}
+
+// draw the portal's surface, showing what was rendered into the target of the given level
+
+func (portal *Portal) Draw(level int) {
+	state := portal.state
+	width, height := state.Size()
+
+	vp := state.player.ViewProjection()
+	eye := state.player.v.Copy().Inverse().TransformPoint([3]float32{0, 0, 0})
+	thickness := portal.Thickness(eye, nearPlaneReach(state.player.p))
+
+	// the box is a metre deep, and models are scaled from metres to world units
+
+	model := NewMat().Multiply(portal.node.World()).Multiply(NewMat().Scale(1, 1, thickness/M_TO_AYLIN))
+
+	uniforms := PortalUniforms{
+		mvp:    vp.Multiply(ModelMatrix(model)).Data,
+		screen: [2]float32{float32(width), float32(height)},
+	}
+
+	state.frame.Upload(portal.uniform_buf, wgpu.ToBytes([]PortalUniforms{uniforms}))
+	target := portal.targets[level-1]
+
+	state.frame.Pass(portal.name+" portal", wgpu.LoadOp_Load, wgpu.LoadOp_Load, func(render_pass *wgpu.RenderPassEncoder) {
+		state.portal_pipeline.Set(render_pass, target.bind_group)
+		render_pass.SetVertexBuffer(0, portal.vbo, 0, wgpu.WholeSize)
+		render_pass.SetIndexBuffer(portal.ibo, wgpu.IndexFormat_Uint32, 0, wgpu.WholeSize)
+		render_pass.DrawIndexed(uint32(len(PORTAL_INDICES)), 1, 0, 0, 0)
+	}).Reads(target.colour.view)
+}
+
+func (portal *Portal) Release() {
+	portal.releaseTargets()
+	portal.vbo.Release()
+	portal.ibo.Release()
+	portal.uniform_buf.Release()
+}
+
+// renders worlds along with what can be seen through their portals
+
+type PortalRenderer struct {
+	state   *State
+	portals []*Portal
+	depth   int
+
+	rendering string // world being rendered, whether directly or through a portal
+}
+
+func NewPortalRenderer(state *State, depth int) *PortalRenderer {
+	return &PortalRenderer{state: state, depth: depth}
+}
+
+func (renderer *PortalRenderer) Add(portal *Portal) {
+	renderer.portals = append(renderer.portals, portal)
+}
+
+func (renderer *PortalRenderer) Get(name string) *Portal {
+	for _, portal := range renderer.portals {
+		if portal.name == name {
+			return portal
+		}
+	}
+
+	return nil
+}
+
+// is the current camera in front of the portal?
+
+func (renderer *PortalRenderer) facing(portal *Portal, view *Mat) bool {
+	eye := view.Copy().Inverse().TransformPoint([3]float32{0, 0, 0})
+	plane := portal.Plane()
+
+	return dot4(plane, [4]float32{eye[0], eye[1], eye[2], 1}) > 0
+}
+
+// render what a portal sees into its target for this level, recursing into the portals visible from there
+// the destination portal itself is left out, as we can only ever see it from behind
+
+func (renderer *PortalRenderer) renderThrough(portal *Portal, view *Mat, level int) {
+	state := renderer.state
+	destination := renderer.Get(portal.destination)
+
+	if destination == nil {
+		log.Printf("Portal %q leads to unknown portal %q\n", portal.name, portal.destination)
+		return
+	}
+
+	world := state.worlds.Get(destination.world)
+
+	if world == nil {
+		return
+	}
+
+	virtual_view := NewMat().Multiply(view).Multiply(PortalTransform(destination.node, portal.node))
+
+	if level < renderer.depth {
+		for _, other := range renderer.portals {
+			if other.world == destination.world && other != destination && other.node.Visible() && renderer.facing(other, virtual_view) {
+				renderer.renderThrough(other, virtual_view, level+1)
+			}
+		}
+	}
+
+	// point the camera and render targets at this level
+
+	target := portal.targets[level-1]
+
+	prev_colour, prev_depth := state.frame.Targets()
+	prev_view, prev_clip := state.player.view_override, state.player.clip_plane
+	prev_rendering := renderer.rendering
+
+	state.frame.SetTargets(target.colour.view, target.depth.view)
+
+	clip := destination.Plane()
+	state.player.view_override = virtual_view
+	state.player.clip_plane = &clip
+	renderer.rendering = destination.world
+
+	state.frame.Pass(portal.name+" portal clear", wgpu.LoadOp_Clear, wgpu.LoadOp_Clear, nil)
+
+	world.Render()
+
+	if level < renderer.depth {
+		for _, other := range renderer.portals {
+			if other.world == destination.world && other != destination && other.node.Visible() && renderer.facing(other, virtual_view) {
+				other.Draw(level + 1)
+			}
+		}
+	}
+
+	state.frame.SetTargets(prev_colour, prev_depth)
+	state.player.view_override, state.player.clip_plane = prev_view, prev_clip
+	renderer.rendering = prev_rendering
+}
+
+// portal gone through when moving between two points, along with its destination
+// only portals which are visible in active worlds can be gone through
+
+func (renderer *PortalRenderer) Crossing(from, to [3]float32) (*Portal, *Portal) {
+	for _, portal := range renderer.portals {
+		if !renderer.state.worlds.IsActive(portal.world) || !portal.node.Visible() || !portal.Crossed(from, to) {
+			continue
+		}
+
+		if destination := renderer.Get(portal.destination); destination != nil {
+			return portal, destination
+		}
+	}
+
+	return nil, nil
+}
+
+// can a world be seen, i.e. is it active or can it be seen through portals from an active world?
+
+func (renderer *PortalRenderer) Seen(name string) bool {
+	worlds := renderer.state.worlds
+
+	if worlds.IsActive(name) {
+		return true
+	}
+
+	view := renderer.state.player.View()
+
+	for _, portal := range renderer.portals {
+		if worlds.IsActive(portal.world) && renderer.seenThrough(name, portal, view, 1) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// this follows the same portals as renderThrough does
+
+func (renderer *PortalRenderer) seenThrough(name string, portal *Portal, view *Mat, level int) bool {
+	if level > renderer.depth || !portal.node.Visible() || !renderer.facing(portal, view) {
+		return false
+	}
+
+	destination := renderer.Get(portal.destination)
+
+	if destination == nil {
+		return false
+	}
+
+	if destination.world == name {
+		return true
+	}
+
+	virtual_view := NewMat().Multiply(view).Multiply(PortalTransform(destination.node, portal.node))
+
+	for _, other := range renderer.portals {
+		if other.world == destination.world && other != destination && renderer.seenThrough(name, other, virtual_view, level+1) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// render a world along with what can be seen through its portals
+
+func (renderer *PortalRenderer) RenderWorld(name string, world World) {
+	state := renderer.state
+	width, height := state.Size()
+
+	if renderer.depth < 1 {
+		renderer.rendering = name
+		world.Render()
+		return
+	}
+
+	for _, portal := range renderer.portals {
+		if err := portal.ensureTargets(renderer.depth, uint32(width), uint32(height)); err != nil {
+			log.Printf("Can't create render targets for portal %q: %v\n", portal.name, err)
+			return
+		}
+	}
+
+	var visible []*Portal
+	view := state.player.View()
+
+	for _, portal := range renderer.portals {
+		if portal.world == name && portal.node.Visible() && renderer.facing(portal, view) {
+			visible = append(visible, portal)
+		}
+	}
+
+	for _, portal := range visible {
+		renderer.renderThrough(portal, view, 1)
+	}
+
+	renderer.rendering = name
+	world.Render()
+
+	for _, portal := range visible {
+		portal.Draw(1)
+	}
+}
+
+func (renderer *PortalRenderer) Release() {
+	for _, portal := range renderer.portals {
+		portal.Release()
+	}
+}
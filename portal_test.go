@@ -0,0 +1,263 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// the portal maths don't need a GPU, so these only make scene nodes and portals without any buffers
+
+const PORTAL_TEST_EPSILON = 1e-4
+
+func newPortalTestState() *State {
+	return &State{nodes: make(map[string]*SceneNode)}
+}
+
+func newTestPortal(state *State, name string, centre, normal, up [3]float32) *Portal {
+	node := NewSceneNode(state, name, nil).SetLocal(NewPortalMat(centre, normal, up))
+
+	return &Portal{
+		state:  state,
+		name:   name,
+		node:   node,
+		width:  2,
+		height: 3,
+	}
+}
+
+func assertVector(t *testing.T, what string, got, expected [3]float32) {
+	t.Helper()
+
+	for i := range got {
+		if math.Abs(float64(got[i]-expected[i])) > PORTAL_TEST_EPSILON {
+			t.Fatalf("%s: got %v, expected %v", what, got, expected)
+		}
+	}
+}
+
+func assertMat(t *testing.T, what string, got, expected *Mat) {
+	t.Helper()
+
+	for i := range got.Data {
+		for j := range got.Data[i] {
+			if math.Abs(float64(got.Data[i][j]-expected.Data[i][j])) > PORTAL_TEST_EPSILON {
+				t.Fatalf("%s: got %v, expected %v", what, got.Data, expected.Data)
+			}
+		}
+	}
+}
+
+// something going into the front of one portal comes out of the front of the other, and going back undoes it
+
+func TestPortalTransformRoundTrip(t *testing.T) {
+	state := newPortalTestState()
+
+	a := newTestPortal(state, "a", [3]float32{0, 0, 0}, [3]float32{0, 0, 1}, [3]float32{0, 1, 0})
+	b := newTestPortal(state, "b", [3]float32{10, 2, -5}, [3]float32{1, 0, 0}, [3]float32{0, 1, 0})
+
+	entity := NewEntity(state, [3]float32{.5, 1, -.25}, [2]float32{}, 1, 2)
+	entity.vel = [3]float32{.1, 0, -3}
+
+	entity.Transform(PortalTransform(a.node, b.node))
+
+	// just behind a comes out just in front of b, and moving into a moves out of b
+
+	assertVector(t, "position through the portal", entity.pos, [3]float32{10.25, 3, -4.5})
+	assertVector(t, "velocity through the portal", entity.vel, [3]float32{3, 0, .1})
+	assertVector(t, "up through the portal", entity.Up(), [3]float32{0, 1, 0})
+
+	entity.Transform(PortalTransform(b.node, a.node))
+
+	assertVector(t, "position back", entity.pos, [3]float32{.5, 1, -.25})
+	assertVector(t, "velocity back", entity.vel, [3]float32{.1, 0, -3})
+	assertMat(t, "orientation back", entity.orientation, NewMat())
+}
+
+// only going from the front of a portal to its back, and within its bounds, goes through it
+
+func TestPortalCrossed(t *testing.T) {
+	state := newPortalTestState()
+	portal := newTestPortal(state, "portal", [3]float32{0, 0, 0}, [3]float32{0, 0, 1}, [3]float32{0, 1, 0})
+
+	w := portal.width * M_TO_AYLIN / 2
+
+	crossings := []struct {
+		name     string
+		from, to [3]float32
+		expected bool
+	}{
+		{"front to back", [3]float32{0, 0, 1}, [3]float32{0, 0, -1}, true},
+		{"back to front", [3]float32{0, 0, -1}, [3]float32{0, 0, 1}, false},
+		{"staying in front", [3]float32{0, 0, 2}, [3]float32{0, 0, 1}, false},
+		{"staying behind", [3]float32{0, 0, -1}, [3]float32{0, 0, -2}, false},
+		{"beside the portal", [3]float32{w * 2, 0, 1}, [3]float32{w * 2, 0, -1}, false},
+	}
+
+	for _, crossing := range crossings {
+		if got := portal.Crossed(crossing.from, crossing.to); got != crossing.expected {
+			t.Errorf("%s: got %v, expected %v", crossing.name, got, crossing.expected)
+		}
+	}
+}
+
+// a portal in a wall leading to one in the floor turns the entity, and so its gravity, sideways
+
+func TestPortalChangesGravity(t *testing.T) {
+	state := newPortalTestState()
+
+	wall := newTestPortal(state, "wall", [3]float32{0, 0, 0}, [3]float32{0, 0, 1}, [3]float32{0, 1, 0})
+	floor := newTestPortal(state, "floor", [3]float32{0, -10, 0}, [3]float32{0, 1, 0}, [3]float32{0, 0, -1})
+
+	entity := NewEntity(state, [3]float32{0, 0, -.5}, [2]float32{}, 1, 2)
+	entity.vel = [3]float32{0, 0, -2}
+
+	if axis, sign := entity.upAxis(); axis != 1 || sign != 1 {
+		t.Fatalf("up axis before the portal: got %d, %v, expected 1, 1", axis, sign)
+	}
+
+	entity.Transform(PortalTransform(wall.node, floor.node))
+
+	assertVector(t, "position", entity.pos, [3]float32{0, -9.5, 0})
+	assertVector(t, "velocity", entity.vel, [3]float32{0, 2, 0})
+	assertVector(t, "up", entity.Up(), [3]float32{0, 0, -1})
+
+	if axis, sign := entity.upAxis(); axis != 2 || sign != -1 {
+		t.Fatalf("up axis after the portal: got %d, %v, expected 2, -1", axis, sign)
+	}
+}
+
+// the near plane of an oblique projection is the portal's plane, so what's between the camera and the portal is clipped
+
+func TestObliqueProjectionClipsAtPortal(t *testing.T) {
+	state := newPortalTestState()
+
+	// looking down -Z from the origin (so with an identity view) at a portal tilted away from the camera, facing away from it like a destination portal does
+
+	portal := newTestPortal(state, "portal", [3]float32{0, 0, -5}, [3]float32{.6, 0, -.8}, [3]float32{0, 1, 0})
+	view := NewMat()
+
+	proj := NewMat().Perspective(math.Pi/2, 1, .01, 50)
+	oblique := ObliqueProjection(proj, TransformPlane(view, portal.Plane()))
+	vp := NewMat().Multiply(oblique).Multiply(view)
+
+	clip := func(point [3]float32) (float32, float32) {
+		var res [4]float32
+
+		for j := range res {
+			res[j] = vp.Data[0][j]*point[0] + vp.Data[1][j]*point[1] + vp.Data[2][j]*point[2] + vp.Data[3][j]
+		}
+
+		return res[2], res[3]
+	}
+
+	plane := portal.Plane()
+
+	for _, x := range []float32{-1, 0, 1} {
+		for _, y := range []float32{-1, 0, 1} {
+			// where the line of sight through (x, y) meets the portal's plane
+
+			z := -(plane[0]*x + plane[1]*y + plane[3]) / plane[2]
+
+			if clip_z, _ := clip([3]float32{x, y, z}); math.Abs(float64(clip_z)) > PORTAL_TEST_EPSILON {
+				t.Errorf("on the portal's plane at (%v, %v, %v): clip z is %v, expected 0", x, y, z, clip_z)
+			}
+
+			if clip_z, _ := clip([3]float32{x, y, z + .5}); clip_z >= 0 {
+				t.Errorf("in front of the portal at (%v, %v, %v): clip z is %v, expected it to be clipped", x, y, z+.5, clip_z)
+			}
+
+			if clip_z, w := clip([3]float32{x, y, z - .5}); clip_z <= 0 || clip_z > w {
+				t.Errorf("behind the portal at (%v, %v, %v): clip z is %v (w %v), expected it to be kept", x, y, z-.5, clip_z, w)
+			}
+		}
+	}
+
+	// only the depth row is changed, so things still land in the same place on screen
+
+	for i := 0; i < 4; i++ {
+		for _, j := range []int{0, 1, 3} {
+			if oblique.Data[i][j] != proj.Data[i][j] {
+				t.Fatalf("oblique projection changed more than depth: %v, expected %v", oblique.Data, proj.Data)
+			}
+		}
+	}
+}
+
+// with WebGPU's depth range, a projection made for OpenGL clips at twice the near distance (give or take)
+
+func TestNearPlaneReach(t *testing.T) {
+	near, far := float32(.01), float32(50)
+	depth := 2 * near * far / (near + far)
+
+	reach := nearPlaneReach(NewMat().Perspective(math.Pi/2, 1, near, far))
+	expected := depth * float32(math.Sqrt(3))
+
+	if math.Abs(float64(reach-expected)) > PORTAL_TEST_EPSILON {
+		t.Fatalf("got %v, expected %v", reach, expected)
+	}
+}
+
+// as the camera goes through a portal, its near plane mustn't reach past the back of the portal, or the portal gets clipped
+
+func TestPortalThicknessCoversNearPlane(t *testing.T) {
+	state := newPortalTestState()
+	portal := newTestPortal(state, "portal", [3]float32{0, 0, 0}, [3]float32{0, 0, 1}, [3]float32{0, 1, 0})
+
+	proj := NewMat().Perspective(PLAYER_FOV, 16./9, PLAYER_NEAR, PLAYER_FAR)
+	reach := nearPlaneReach(proj)
+	plane := portal.Plane()
+
+	for _, dist := range []float32{reach, reach / 2, reach / 10, 0} {
+		for _, look := range [][3]float32{{0, 0, -1}, {.5, 0, -1}, {-.3, .4, -1}, {1, 0, -.1}} {
+			eye := [3]float32{.2, -.5, dist}
+			view := NewMat().LookAt(eye, [3]float32{eye[0] + look[0], eye[1] + look[1], eye[2] + look[2]}, [3]float32{0, 1, 0})
+			inv := NewMat().Multiply(proj).Multiply(view).Inverse()
+
+			thickness := portal.Thickness(eye, reach)
+
+			for _, x := range []float32{-1, 1} {
+				for _, y := range []float32{-1, 1} {
+					var corner [4]float32
+
+					for j := range corner {
+						corner[j] = inv.Data[0][j]*x + inv.Data[1][j]*y + inv.Data[3][j]
+					}
+
+					corner_dist := dot4(plane, [4]float32{corner[0] / corner[3], corner[1] / corner[3], corner[2] / corner[3], 1})
+
+					if corner_dist < -thickness-PORTAL_TEST_EPSILON {
+						t.Errorf("%v in front looking towards %v: the near plane reaches %v behind the portal, but it's only %v thick", dist, look, -corner_dist, thickness)
+					}
+				}
+			}
+		}
+	}
+}
+
+// the portal is only thickened when the camera is right in front of it
+
+func TestPortalThickness(t *testing.T) {
+	state := newPortalTestState()
+	portal := newTestPortal(state, "portal", [3]float32{0, 0, 0}, [3]float32{0, 0, 1}, [3]float32{0, 1, 0})
+
+	reach := float32(.05)
+	w := portal.width * M_TO_AYLIN / 2
+
+	eyes := []struct {
+		name     string
+		eye      [3]float32
+		expected float32
+	}{
+		{"right in front", [3]float32{0, 0, reach / 2}, reach},
+		{"in front near the edge", [3]float32{w + reach/2, 0, reach / 2}, reach},
+		{"further in front", [3]float32{0, 0, 1}, 0},
+		{"behind", [3]float32{0, 0, -reach / 2}, 0},
+		{"beside the portal", [3]float32{w * 2, 0, reach / 2}, 0},
+	}
+
+	for _, eye := range eyes {
+		if got := portal.Thickness(eye.eye, reach); got != eye.expected {
+			t.Errorf("%s: got %v, expected %v", eye.name, got, eye.expected)
+		}
+	}
+}
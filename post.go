@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+//go:embed res/post.csv
+var post_csv []byte
+
+// effects with the names of their parameters, in the order the shader reads them, and their defaults
+
+type PostEffectKind struct {
+	params   []string
+	defaults []float32
+}
+
+var POST_EFFECTS = map[string]PostEffectKind{
+	"invert":   {[]string{"amount"}, []float32{1}},
+	"bloom":    {[]string{"threshold", "intensity", "radius"}, []float32{1, .5, 4}},
+	"tonemap":  {[]string{"exposure"}, []float32{1}},
+	"vignette": {[]string{"strength", "radius"}, []float32{.4, .5}},
+	"fade":     {[]string{"amount"}, []float32{0}},
+}
+
+const POST_MAX_PARAMS = 8
+
+type PostUniforms struct {
+	screen [2]float32
+	time   float32
+	fade   float32
+	params [POST_MAX_PARAMS]float32
+}
+
+// an effect in a world's chain, with its own parameters
+// bind_groups[i] reads from the i-th of the ping-pong targets
+
+type PostEffect struct {
+	name        string
+	params      [POST_MAX_PARAMS]float32
+	condition   string // flags which must hold for the effect to be applied
+	uniform_buf *wgpu.Buffer
+	bind_groups [2]*wgpu.BindGroup
+}
+
+// the scene is rendered into an HDR target, which then goes through each effect of the active world's chain
+// effects ping-pong between two targets, and the result is copied to the swapchain
+
+type Post struct {
+	state *State
+
+	pipelines map[string]*Pipeline
+	blit      *PostEffect
+
+	chains map[string][]*PostEffect
+
+	targets       [2]*Texture
+	target_width  uint32
+	target_height uint32
+
+	time float32
+}
+
+func postPipeline(state *State, name string, format wgpu.TextureFormat) (*Pipeline, error) {
+	descriptor := DEFAULT_PIPELINE_DESCRIPTOR
+	descriptor.label = fmt.Sprintf("Post (%s)", name)
+	descriptor.shader = "post/" + name + ".wgsl"
+	descriptor.format = format
+	descriptor.depth = false
+
+	return state.resources.Pipeline(descriptor)
+}
+
+func NewPost(state *State) (*Post, error) {
+	post := &Post{
+		state:     state,
+		pipelines: make(map[string]*Pipeline),
+		chains:    make(map[string][]*PostEffect),
+	}
+
+	for name := range POST_EFFECTS {
+		pipeline, err := postPipeline(state, name, HDR_FORMAT)
+
+		if err != nil {
+			post.Release()
+			return nil, fmt.Errorf("post effect %q: %w", name, err)
+		}
+
+		post.pipelines[name] = pipeline
+	}
+
+	// the blit pass is the only one drawing to the swapchain
+
+	blit, err := postPipeline(state, "blit", state.config.Format)
+
+	if err != nil {
+		post.Release()
+		return nil, err
+	}
+
+	post.pipelines["blit"] = blit
+
+	if post.blit, err = post.newEffect("blit", nil); err != nil {
+		post.Release()
+		return nil, err
+	}
+
+	if err := post.loadChains(post_csv); err != nil {
+		post.Release()
+		return nil, err
+	}
+
+	return post, nil
+}
+
+func (post *Post) newEffect(name string, params []float32) (*PostEffect, error) {
+	effect := &PostEffect{name: name}
+	copy(effect.params[:], params)
+
+	var err error
+
+	if effect.uniform_buf, err = post.state.device.CreateBuffer(&wgpu.BufferDescriptor{
+		Label: fmt.Sprintf("Uniforms (post %s)", name),
+		Size:  uint64(unsafe.Sizeof(PostUniforms{})),
+		Usage: wgpu.BufferUsage_Uniform | wgpu.BufferUsage_CopyDst,
+	}); err != nil {
+		return nil, err
+	}
+
+	return effect, nil
+}
+
+// chains are given per world, one effect per line, e.g.:
+// apat,bloom,threshold=.8 intensity=.6,
+// effects for the "*" world are added to the end of every world's chain
+// effects with a condition are only applied while it holds, e.g. apat,invert,,!portal_lit
+// the scene is rendered in HDR, so chains without a tonemap get a default one, before the effects for every world
+
+func (post *Post) loadChains(chains_csv []byte) error {
+	records, err := csv.NewReader(bytes.NewReader(chains_csv)).ReadAll()
+	if err != nil {
+		return err
+	}
+
+	var common []*PostEffect
+
+	for i := 1; i < len(records); i++ {
+		record := records[i]
+
+		if len(record) != 4 {
+			return fmt.Errorf("post chains: line %d has %d fields, expected 4", i+1, len(record))
+		}
+
+		world, name := record[0], record[1]
+		kind, ok := POST_EFFECTS[name]
+
+		if !ok {
+			return fmt.Errorf("post chains: line %d: unknown effect %q", i+1, name)
+		}
+
+		params := append([]float32(nil), kind.defaults...)
+
+		for _, field := range strings.Fields(record[2]) {
+			key, value, _ := strings.Cut(field, "=")
+			index := -1
+
+			for j, param := range kind.params {
+				if param == key {
+					index = j
+				}
+			}
+
+			if index < 0 {
+				return fmt.Errorf("post chains: line %d: effect %q has no parameter %q", i+1, name, key)
+			}
+
+			parsed, err := strconv.ParseFloat(value, 32)
+			if err != nil {
+				return fmt.Errorf("post chains: line %d: %v", i+1, err)
+			}
+
+			params[index] = float32(parsed)
+		}
+
+		effect, err := post.newEffect(name, params)
+		if err != nil {
+			return err
+		}
+
+		effect.condition = record[3]
+
+		if world == "*" {
+			common = append(common, effect)
+		} else {
+			post.chains[world] = append(post.chains[world], effect)
+		}
+	}
+
+	tonemap, err := post.newEffect("tonemap", POST_EFFECTS["tonemap"].defaults)
+	if err != nil {
+		return err
+	}
+
+	for _, world := range world_names {
+		if !hasEffect(post.chains[world], "tonemap") {
+			post.chains[world] = append(post.chains[world], tonemap)
+		}
+
+		post.chains[world] = append(post.chains[world], common...)
+	}
+
+	return nil
+}
+
+func hasEffect(chain []*PostEffect, name string) bool {
+	for _, effect := range chain {
+		if effect.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (post *Post) ensureTargets(width, height uint32) error {
+	if post.target_width == width && post.target_height == height {
+		return nil
+	}
+
+	post.releaseTargets()
+
+	for i := range post.targets {
+		target, err := NewRenderTexture(post.state, fmt.Sprintf("Post target %d", i), HDR_FORMAT, width, height)
+
+		if err != nil {
+			return err
+		}
+
+		post.targets[i] = target
+	}
+
+	post.target_width = width
+	post.target_height = height
+
+	return nil
+}
+
+func (post *Post) releaseTargets() {
+	for i, target := range post.targets {
+		if target != nil {
+			target.Release()
+			post.targets[i] = nil
+		}
+	}
+
+	for _, effect := range post.effects() {
+		for i, bind_group := range effect.bind_groups {
+			if bind_group != nil {
+				bind_group.Release()
+				effect.bind_groups[i] = nil
+			}
+		}
+	}
+
+	post.target_width = 0
+	post.target_height = 0
+}
+
+func (post *Post) effects() []*PostEffect {
+	var effects []*PostEffect
+
+	if post.blit != nil {
+		effects = append(effects, post.blit)
+	}
+
+	// effects for every world are shared between chains
+
+	seen := make(map[*PostEffect]bool)
+
+	for _, chain := range post.chains {
+		for _, effect := range chain {
+			if !seen[effect] {
+				seen[effect] = true
+				effects = append(effects, effect)
+			}
+		}
+	}
+
+	return effects
+}
+
+func (post *Post) bindGroup(effect *PostEffect, input int) (*wgpu.BindGroup, error) {
+	if effect.bind_groups[input] != nil {
+		return effect.bind_groups[input], nil
+	}
+
+	bind_group, err := post.state.device.CreateBindGroup(&wgpu.BindGroupDescriptor{
+		Layout: post.pipelines[effect.name].bind_group_layout,
+		Entries: []wgpu.BindGroupEntry{
+			{
+				Binding:     0,
+				TextureView: post.targets[input].view,
+			},
+			{
+				Binding: 1,
+				Sampler: post.targets[input].sampler,
+			},
+			{
+				Binding: 2,
+				Buffer:  effect.uniform_buf,
+				Size:    wgpu.WholeSize,
+			},
+		},
+	})
+
+	effect.bind_groups[input] = bind_group
+	return bind_group, err
+}
+
+// where the scene should be rendered to this frame
+
+func (post *Post) SceneView() *wgpu.TextureView {
+	width, height := post.state.Size()
+
+	if err := post.ensureTargets(uint32(width), uint32(height)); err != nil {
+		log.Fatal(err)
+	}
+
+	return post.targets[0].view
+}
+
+func (post *Post) pass(effect *PostEffect, input int, output *wgpu.TextureView) {
+	state := post.state
+
+	bind_group, err := post.bindGroup(effect, input)
+	if err != nil {
+		log.Printf("Can't create bind group for post effect %q: %v\n", effect.name, err)
+		return
+	}
+
+	uniforms := PostUniforms{
+		screen: [2]float32{float32(post.target_width), float32(post.target_height)},
+		time:   post.time,
+		fade:   state.worlds.TransitionFade(),
+		params: effect.params,
+	}
+
+	state.frame.Upload(effect.uniform_buf, wgpu.ToBytes([]PostUniforms{uniforms}))
+	state.frame.SetTargets(output, nil)
+
+	pipeline := post.pipelines[effect.name]
+
+	state.frame.Pass("post "+effect.name, wgpu.LoadOp_Clear, wgpu.LoadOp_Clear, func(render_pass *wgpu.RenderPassEncoder) {
+		pipeline.Set(render_pass, bind_group)
+		render_pass.Draw(3, 1, 0, 0)
+	}).Reads(post.targets[input].view)
+}
+
+// run the scene through a world's chain and onto the swapchain
+
+func (post *Post) Apply(world string, output *wgpu.TextureView) {
+	post.time += post.state.dt
+	input := 0
+
+	for _, effect := range post.chains[world] {
+		if !post.state.flags.Check(effect.condition) {
+			continue
+		}
+
+		post.pass(effect, input, post.targets[1-input].view)
+		input = 1 - input
+	}
+
+	post.pass(post.blit, input, output)
+}
+
+func (post *Post) Release() {
+	post.releaseTargets()
+
+	for _, effect := range post.effects() {
+		effect.uniform_buf.Release()
+	}
+
+	for _, pipeline := range post.pipelines {
+		post.state.resources.Release(pipeline)
+	}
+}
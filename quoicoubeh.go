@@ -1,7 +1,10 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"os"
 	"runtime"
 
 	"github.com/go-gl/glfw/v3.3/glfw"
@@ -16,26 +19,36 @@ func init() {
 }
 
 type State struct {
-	win                 *glfw.Window
-	instance            *wgpu.Instance
-	surface             *wgpu.Surface
-	adapter             *wgpu.Adapter
-	device              *wgpu.Device
-	queue               *wgpu.Queue
-	config              *wgpu.SwapChainDescriptor
-	swapchain           *wgpu.SwapChain
-	depth_texture       *Texture
-	render_pass_manager *RenderPassManager
-	text                *Text
-	player              *Player
-	prev_time           float64
-	dt                  float32
-
-	// worlds
-
-	alexis_room *WorldAlexisRoom
-	apat        *WorldApat
-	obama_room  *WorldObama
+	win           *glfw.Window
+	instance      *wgpu.Instance
+	surface       *wgpu.Surface
+	adapter       *wgpu.Adapter
+	device        *wgpu.Device
+	queue         *wgpu.Queue
+	config        *wgpu.SwapChainDescriptor
+	swapchain     *wgpu.SwapChain
+	depth_texture *Texture
+	frame         *FrameGraph
+	resources     *ResourceCache
+	materials     map[string]*MaterialDefinition
+	uniforms      *UniformRing
+	lights        *Lights
+	portals       *PortalRenderer
+	player        *Player
+	prev_time     float64
+	dt            float32
+
+	// story
+
+	flags    Flags
+	events   *Events
+	strings  *StringTable
+	dialogue *Dialogue
+	timeline *TimelinePlayer
+	rewind   *Rewind
+	nodes    map[string]*SceneNode
+
+	worlds *WorldManager
 
 	decodeded_sounds map[string]*DecodedSound
 
@@ -43,6 +56,17 @@ type State struct {
 
 	regular_pipeline *RegularPipeline
 	text_pipeline    *TextPipeline
+	portal_pipeline  *PortalPipeline
+	shadow_pipeline  *ShadowPipeline
+
+	post    *Post
+	capture *Capture
+}
+
+// size of what's rendered to, i.e. the window, or the offscreen target when headless
+
+func (state *State) Size() (int, int) {
+	return int(state.config.Width), int(state.config.Height)
 }
 
 func (state *State) resize(width, height int) {
@@ -78,10 +102,22 @@ func (state *State) resize(width, height int) {
 }
 
 func (state *State) update() {
+	state.capture.Update()
+	state.rewind.Update()
+
+	// while rewinding, everything is played back from history instead
+
+	if state.rewind.Rewinding() {
+		return
+	}
+
+	state.timeline.Update()
 	state.player.Update()
+	state.worlds.Update()
+	state.dialogue.Update()
 }
 
-func (state *State) render() {
+func (state *State) present() {
 	next_tex, err := state.swapchain.GetCurrentTextureView()
 	if err != nil {
 		log.Fatal(err)
@@ -89,69 +125,146 @@ func (state *State) render() {
 	}
 	defer next_tex.Release()
 
-	encoder, err := state.device.CreateCommandEncoder(&wgpu.CommandEncoderDescriptor{
-		Label: "Command encoder",
-	})
-	if err != nil {
-		log.Fatal(err)
-		return
+	// the swapchain's texture can't be copied from, so frames being captured are rendered offscreen first
+
+	capturing := state.capture.Wanted()
+	output := next_tex
+
+	if capturing {
+		output = state.capture.View()
 	}
-	defer encoder.Release()
 
-	state.render_pass_manager.next_tex = next_tex
-	state.render_pass_manager.encoder = encoder
+	state.render(output)
 
-	state.apat.Render()
-	state.alexis_room.Render()
-	state.obama_room.Render()
+	if capturing {
+		state.capture.Blit(next_tex)
+	}
 
-	// draw text
+	state.frame.Execute()
 
-	/*
-		state.render_pass_manager.Begin(wgpu.LoadOp_Load, wgpu.LoadOp_Clear)
-		state.text.Draw(state.render_pass_manager.render_pass)
-		state.render_pass_manager.End()
-	*/
+	if capturing {
+		state.capture.Read()
+	}
 
+	state.capture.Poll()
 	state.swapchain.Present()
 }
 
+// declare the passes of a frame rendering to output, which is in the format of state.config
+// they're only executed once the caller has added whatever else it needs to the frame
+
+func (state *State) render(output *wgpu.TextureView) {
+	state.frame.SetTargets(state.post.SceneView(), state.depth_texture.view)
+	state.lights.RenderShadows()
+	state.worlds.Render()
+
+	// post-processing is chosen by whichever world is on top
+
+	world := ""
+
+	if active := state.worlds.Active(); len(active) > 0 {
+		world = active[len(active)-1]
+	}
+
+	state.post.Apply(world, output)
+
+	// draw dialogue text, which isn't post-processed
+
+	state.frame.SetTargets(output, state.depth_texture.view)
+
+	if state.dialogue.queue.Showing() {
+		state.frame.Pass("text", wgpu.LoadOp_Load, wgpu.LoadOp_Clear, state.dialogue.queue.Draw)
+	}
+}
+
 func main() {
+	lang := flag.String("lang", "", "language of dialogues and UI text (defaults to $QUOICOUBEH_LANG or $LANG)")
+	check_strings := flag.Bool("check-strings", false, "check all strings are translated in every language and exit")
+	portal_depth := flag.Int("portal-depth", DEFAULT_PORTAL_DEPTH, "how many portals deep can be seen through portals")
+	shadow_resolution := flag.Uint("shadow-resolution", DEFAULT_SHADOW_RESOLUTION, "width and height of each shadow map")
+	shadow_filter := flag.Int("shadow-filter", DEFAULT_SHADOW_FILTER, "how soft shadow edges are, 0 for hard edges (each step costs more)")
+	stats := flag.Bool("stats", false, "log how many draws were made and culled every second")
+	headless_output := flag.String("headless", "", "render a single frame offscreen without a window, to this PNG file, and exit")
+	headless_world := flag.String("headless-world", START_WORLDS[0], "world to render when headless")
+	headless_camera := flag.String("headless-camera", "0,0,0,1.5708,0", "camera position and rotation when headless, as x,y,z,yaw,pitch")
+	headless_size := flag.String("headless-size", "800x600", "size of the image rendered when headless")
+	headless_flags := flag.String("headless-flags", "", "comma-separated story flags to set before rendering when headless")
+	headless_golden := flag.String("headless-golden", "", "golden PNG to compare the headless render with, failing if they differ by more than the tolerance")
+	headless_tolerance := flag.String("headless-tolerance", fmt.Sprintf("%d,%g", DEFAULT_GOLDEN_TOLERANCE.threshold, DEFAULT_GOLDEN_TOLERANCE.fraction), "how much a headless render may differ from its golden image, as the largest difference in a channel which is ignored, and the fraction of pixels which may differ by more")
+	screenshots := flag.String("screenshots", "screenshots", "directory screenshots are saved to")
+	screenshot_at := flag.String("screenshot-at", "", "comma-separated seconds into the game at which to take screenshots")
+	record := flag.String("record", "", "capture every frame to this directory, with time going by a fixed timestep")
+	record_fps := flag.Float64("record-fps", 60, "frames per second of game time when recording")
+	flag.Parse()
+
+	headless := *headless_output != ""
+
 	state := State{}
+	var err error
 
-	log.Println("Create GLFW window")
+	log.Println("Load string table")
 
-	if err := glfw.Init(); err != nil {
+	if state.strings, err = NewStringTable(strings_csv, SelectLocale(*lang)); err != nil {
 		panic(err)
 	}
-	defer glfw.Terminate()
 
-	mon_width, mon_height := glfw.GetPrimaryMonitor().GetContentScale()
+	if *check_strings {
+		if problems := CheckStrings(&state); problems > 0 {
+			log.Printf("Found %d problems with strings\n", problems)
+			os.Exit(1)
+		}
+
+		log.Println("All strings are OK")
+		return
+	}
 
-	if runtime.GOOS != "darwin" && (mon_width != 1 || mon_height != 1) {
-		panic("Monitor scaling is not 1:1 and not on macOS, things might explode, aborting now")
+	for _, missing := range state.strings.Missing() {
+		log.Printf("Missing string: %s\n", missing)
 	}
 
-	glfw.WindowHint(glfw.ClientAPI, glfw.NoAPI) // tell GLFW not to create an OpenGL context automatically
-	// TODO once this is added to go-gl/glfw, unset GLFW_SCALE_FRAMEBUFFER
+	// headless, there's no window nor surface, and frames are rendered offscreen instead
 
-	var err error
+	var options *HeadlessOptions
 
-	if state.win, err = glfw.CreateWindow(800, 600, "Quoicoubeh", nil, nil); err != nil {
-		panic(err)
+	if headless {
+		if options, err = ParseHeadlessOptions(*headless_output, *headless_world, *headless_camera, *headless_size, *headless_flags, *headless_golden, *headless_tolerance); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		log.Println("Create GLFW window")
+
+		if err := glfw.Init(); err != nil {
+			panic(err)
+		}
+		defer glfw.Terminate()
+
+		mon_width, mon_height := glfw.GetPrimaryMonitor().GetContentScale()
+
+		if runtime.GOOS != "darwin" && (mon_width != 1 || mon_height != 1) {
+			panic("Monitor scaling is not 1:1 and not on macOS, things might explode, aborting now")
+		}
+
+		glfw.WindowHint(glfw.ClientAPI, glfw.NoAPI) // tell GLFW not to create an OpenGL context automatically
+		// TODO once this is added to go-gl/glfw, unset GLFW_SCALE_FRAMEBUFFER
+
+		if state.win, err = glfw.CreateWindow(800, 600, state.strings.Get("ui.title"), nil, nil); err != nil {
+			panic(err)
+		}
+		defer state.win.Destroy()
 	}
-	defer state.win.Destroy()
 
 	log.Println("Create WebGPU instance")
 
 	state.instance = wgpu.CreateInstance(nil)
 	defer state.instance.Release()
 
-	log.Println("Create WebGPU surface")
+	if !headless {
+		log.Println("Create WebGPU surface")
 
-	surface_descr := wgpuext_glfw.GetSurfaceDescriptor(state.win)
-	state.surface = state.instance.CreateSurface(surface_descr)
-	defer state.surface.Release()
+		surface_descr := wgpuext_glfw.GetSurfaceDescriptor(state.win)
+		state.surface = state.instance.CreateSurface(surface_descr)
+		defer state.surface.Release()
+	}
 
 	log.Println("Request WebGPU adapter")
 
@@ -162,11 +275,21 @@ func main() {
 		backend_type = wgpu.BackendType_OpenGL
 	}
 
+	// headless, a software adapter is preferred so machines without a GPU render the same thing, but any will do
+
 	if state.adapter, err = state.instance.RequestAdapter(&wgpu.RequestAdapterOptions{
-		ForceFallbackAdapter: false,
+		ForceFallbackAdapter: headless,
 		BackendType:          backend_type,
 		CompatibleSurface:    state.surface,
-	}); err != nil {
+	}); err != nil && headless {
+		log.Println("No fallback adapter, trying any other adapter:", err)
+
+		state.adapter, err = state.instance.RequestAdapter(&wgpu.RequestAdapterOptions{
+			BackendType: backend_type,
+		})
+	}
+
+	if err != nil {
 		panic(err)
 	}
 	defer state.adapter.Release()
@@ -178,7 +301,22 @@ func main() {
 
 	log.Println("Request WebGPU device")
 
-	if state.device, err = state.adapter.RequestDevice(nil); err != nil {
+	// compressed textures can only be used if the adapter supports them
+
+	var features []wgpu.FeatureName
+
+	for _, feature := range TEXTURE_COMPRESSION_FEATURES {
+		if state.adapter.HasFeature(feature) {
+			features = append(features, feature)
+		}
+	}
+
+	log.Println("Adapter texture compression features:\t", features)
+
+	if state.device, err = state.adapter.RequestDevice(&wgpu.DeviceDescriptor{
+		Label:            "Device",
+		RequiredFeatures: features,
+	}); err != nil {
 		panic(err)
 	}
 	defer state.device.Release()
@@ -188,24 +326,45 @@ func main() {
 	state.queue = state.device.GetQueue()
 	defer state.queue.Release()
 
-	log.Println("Create WebGPU swapchain")
+	state.resources = NewResourceCache(&state)
+	defer state.resources.Shutdown()
 
-	caps := state.surface.GetCapabilities(state.adapter)
-	width, height := state.win.GetSize()
+	if headless {
+		state.config = options.Config()
+	} else {
+		log.Println("Create WebGPU swapchain")
+
+		caps := state.surface.GetCapabilities(state.adapter)
+		width, height := state.win.GetSize()
+
+		state.config = &wgpu.SwapChainDescriptor{
+			Usage:       wgpu.TextureUsage_RenderAttachment,
+			Format:      caps.Formats[0],
+			Width:       uint32(width),
+			Height:      uint32(height),
+			PresentMode: wgpu.PresentMode_Fifo,
+			AlphaMode:   caps.AlphaModes[0],
+		}
 
-	state.config = &wgpu.SwapChainDescriptor{
-		Usage:       wgpu.TextureUsage_RenderAttachment,
-		Format:      caps.Formats[0],
-		Width:       uint32(width),
-		Height:      uint32(height),
-		PresentMode: wgpu.PresentMode_Fifo,
-		AlphaMode:   caps.AlphaModes[0],
+		if state.swapchain, err = state.device.CreateSwapChain(state.surface, state.config); err != nil {
+			panic(err)
+		}
+		defer state.swapchain.Release()
 	}
 
-	if state.swapchain, err = state.device.CreateSwapChain(state.surface, state.config); err != nil {
+	log.Println("Create uniform ring")
+
+	if state.uniforms, err = NewUniformRing(&state); err != nil {
 		panic(err)
 	}
-	defer state.swapchain.Release()
+	defer state.uniforms.Release()
+
+	log.Println("Create lights")
+
+	if state.lights, err = NewLights(&state, uint32(*shadow_resolution), *shadow_filter); err != nil {
+		panic(err)
+	}
+	defer state.lights.Release()
 
 	log.Println("Create WebGPU regular pipeline")
 
@@ -214,6 +373,12 @@ func main() {
 	}
 	defer state.regular_pipeline.Release()
 
+	log.Println("Load materials")
+
+	if state.materials, err = LoadMaterials(materials_csv); err != nil {
+		panic(err)
+	}
+
 	log.Println("Create WebGPU text pipeline")
 
 	if state.text_pipeline, err = NewTextPipeline(&state); err != nil {
@@ -221,43 +386,75 @@ func main() {
 	}
 	defer state.text_pipeline.Release()
 
-	log.Println("Create depth texture")
+	log.Println("Create WebGPU portal pipeline")
 
-	if state.depth_texture, err = NewDepthTexture(&state); err != nil {
+	if state.portal_pipeline, err = NewPortalPipeline(&state); err != nil {
 		panic(err)
 	}
-	defer state.depth_texture.Release()
+	defer state.portal_pipeline.Release()
 
-	log.Println("Create render pass manager")
-	state.render_pass_manager = NewRenderPassManager(&state)
+	log.Println("Create WebGPU shadow pipeline")
 
-	log.Println("Create player")
+	if state.shadow_pipeline, err = NewShadowPipeline(&state); err != nil {
+		panic(err)
+	}
+	defer state.shadow_pipeline.Release()
+
+	log.Println("Create post-processing")
 
-	if state.player, err = NewPlayer(&state); err != nil {
+	if state.post, err = NewPost(&state); err != nil {
 		panic(err)
 	}
-	defer state.player.Release()
+	defer state.post.Release()
 
-	log.Println("Create Alexis' room")
+	log.Println("Create capture")
 
-	if state.alexis_room, err = NewWorldAlexisRoom(&state); err != nil {
+	var screenshot_times []float64
+
+	if screenshot_times, err = ParseScreenshotTimes(*screenshot_at); err != nil {
 		panic(err)
 	}
-	defer state.alexis_room.Release()
 
-	log.Println("Create Apat")
+	if *record != "" {
+		state.capture = NewCapture(&state, *record, screenshot_times, *record_fps)
+	} else {
+		state.capture = NewCapture(&state, *screenshots, screenshot_times, 0)
+	}
+	defer state.capture.Release()
 
-	if state.apat, err = NewWorldApat(&state); err != nil {
+	log.Println("Create depth texture")
+
+	if state.depth_texture, err = NewDepthTexture(&state); err != nil {
 		panic(err)
 	}
-	defer state.apat.Release()
+	defer state.depth_texture.Release()
 
-	log.Println("Create Obama room")
+	log.Println("Create frame graph")
 
-	if state.obama_room, err = NewWorldObama(&state); err != nil {
+	state.frame = NewFrameGraph(&state)
+	defer state.frame.Release()
+
+	log.Println("Create player")
+
+	state.player = NewPlayer(&state)
+
+	state.flags = make(Flags)
+	state.events = NewEvents()
+	state.nodes = make(map[string]*SceneNode)
+
+	state.portals = NewPortalRenderer(&state, *portal_depth)
+	defer state.portals.Release()
+
+	log.Println("Create worlds")
+
+	if state.worlds, err = NewWorldManager(&state); err != nil {
 		panic(err)
 	}
-	defer state.apat.Release()
+	defer state.worlds.Release()
+
+	for _, name := range START_WORLDS {
+		state.worlds.Activate(name)
+	}
 
 	log.Println("Decode sounds")
 
@@ -279,24 +476,58 @@ func main() {
 	state.decodeded_sounds["ukulele4"] = DecodeFile("res/sound/ukulele4.mp3")
 	state.decodeded_sounds["ukulele5"] = DecodeFile("res/sound/ukulele5.mp3")
 
-	log.Println("Start main loop")
+	log.Println("Load dialogues")
 
-	log.Println("Create text")
+	if state.dialogue, err = NewDialogue(&state, dialogues_csv, dialogue_choices_csv); err != nil {
+		panic(err)
+	}
 
-	displayDialogue(getDialogues(), "intro1", &state)
+	log.Println("Load timelines")
+
+	if state.timeline, err = NewTimelinePlayer(&state); err != nil {
+		panic(err)
+	}
+
+	state.rewind = NewRewind(&state)
+
+	if headless {
+		if err := state.RenderHeadless(options); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	log.Println("Start main loop")
+
+	state.dialogue.Start("intro1")
 
 	state.win.SetSizeCallback(func(_ *glfw.Window, width, height int) {
 		state.resize(width, height)
 	})
 
+	next_stats := float64(0)
+
 	for !state.win.ShouldClose() {
 		// Calculate delta time
 		current_time := glfw.GetTime()
-		state.dt = float32(current_time - state.prev_time)
-		state.prev_time = current_time
+
+		if state.capture.Recording() {
+			state.dt = state.capture.Timestep()
+			state.prev_time += float64(state.dt)
+		} else {
+			state.dt = float32(current_time - state.prev_time)
+			state.prev_time = current_time
+		}
 
 		glfw.PollEvents()
 		state.update()
-		state.render()
+		state.present()
+
+		if *stats && current_time >= next_stats {
+			frame_stats := state.frame.Stats()
+			log.Printf("%d draws, %d culled\n", frame_stats.draws-frame_stats.culled, frame_stats.culled)
+			next_stats = current_time + 1
+		}
 	}
 }
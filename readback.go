@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// copy of an RGBA8 or BGRA8 texture on its way back from the GPU
+// the copy is submitted and the buffer mapped straight away, but it's only done once the device has been polled past it
+
+type Readback struct {
+	buffer *wgpu.Buffer
+	format wgpu.TextureFormat
+
+	width  uint32
+	height uint32
+
+	// rows in the buffer are padded to what WebGPU requires of copies
+
+	row        uint32
+	padded_row uint32
+	size       uint64
+
+	done   bool
+	status wgpu.BufferMapAsyncStatus
+}
+
+func NewReadback(state *State, texture *wgpu.Texture, format wgpu.TextureFormat, width, height uint32) (*Readback, error) {
+	row := width * 4
+
+	readback := &Readback{
+		format:     format,
+		width:      width,
+		height:     height,
+		row:        row,
+		padded_row: (row + wgpu.CopyBytesPerRowAlignment - 1) / wgpu.CopyBytesPerRowAlignment * wgpu.CopyBytesPerRowAlignment,
+	}
+
+	readback.size = uint64(readback.padded_row) * uint64(height)
+	var err error
+
+	if readback.buffer, err = state.device.CreateBuffer(&wgpu.BufferDescriptor{
+		Label: "Readback buffer",
+		Size:  readback.size,
+		Usage: wgpu.BufferUsage_MapRead | wgpu.BufferUsage_CopyDst,
+	}); err != nil {
+		return nil, err
+	}
+
+	encoder, err := state.device.CreateCommandEncoder(&wgpu.CommandEncoderDescriptor{
+		Label: "Readback command encoder",
+	})
+	if err != nil {
+		readback.Release()
+		return nil, err
+	}
+	defer encoder.Release()
+
+	if err := encoder.CopyTextureToBuffer(
+		&wgpu.ImageCopyTexture{
+			Texture: texture,
+			Aspect:  wgpu.TextureAspect_All,
+		},
+		&wgpu.ImageCopyBuffer{
+			Buffer: readback.buffer,
+			Layout: wgpu.TextureDataLayout{
+				BytesPerRow:  readback.padded_row,
+				RowsPerImage: height,
+			},
+		},
+		&wgpu.Extent3D{
+			Width:              width,
+			Height:             height,
+			DepthOrArrayLayers: 1,
+		},
+	); err != nil {
+		readback.Release()
+		return nil, err
+	}
+
+	cmd_buf, err := encoder.Finish(nil)
+	if err != nil {
+		readback.Release()
+		return nil, err
+	}
+	defer cmd_buf.Release()
+
+	state.queue.Submit(cmd_buf)
+
+	if err := readback.buffer.MapAsync(wgpu.MapMode_Read, 0, readback.size, func(status wgpu.BufferMapAsyncStatus) {
+		readback.status = status
+		readback.done = true
+	}); err != nil {
+		readback.Release()
+		return nil, err
+	}
+
+	return readback, nil
+}
+
+func (readback *Readback) Done() bool {
+	return readback.done
+}
+
+// the copied image, once done, unpadded and with its channels in RGBA order
+
+func (readback *Readback) Image() (*image.RGBA, error) {
+	if !readback.done {
+		return nil, fmt.Errorf("readback isn't done yet")
+	}
+
+	if readback.status != wgpu.BufferMapAsyncStatus_Success {
+		return nil, fmt.Errorf("can't map readback buffer: %v", readback.status)
+	}
+
+	defer readback.buffer.Unmap()
+
+	data := readback.buffer.GetMappedRange(0, uint(readback.size))
+	img := image.NewRGBA(image.Rect(0, 0, int(readback.width), int(readback.height)))
+
+	for y := uint32(0); y < readback.height; y++ {
+		copy(img.Pix[y*readback.row:(y+1)*readback.row], data[y*readback.padded_row:y*readback.padded_row+readback.row])
+	}
+
+	if readback.format == wgpu.TextureFormat_BGRA8Unorm || readback.format == wgpu.TextureFormat_BGRA8UnormSrgb {
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+2] = img.Pix[i+2], img.Pix[i]
+		}
+	}
+
+	return img, nil
+}
+
+func (readback *Readback) Release() {
+	readback.buffer.Release()
+}
+
+// copy a texture back from the GPU, waiting for it to be done
+
+func ReadTexture(state *State, texture *wgpu.Texture, format wgpu.TextureFormat, width, height uint32) (*image.RGBA, error) {
+	readback, err := NewReadback(state, texture, format, width, height)
+	if err != nil {
+		return nil, err
+	}
+	defer readback.Release()
+
+	state.device.Poll(true, nil)
+	return readback.Image()
+}
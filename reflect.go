@@ -0,0 +1,537 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// just enough WGSL reflection to build bind group layouts from the resources shaders declare, and to check vertex buffers against their inputs
+// it works on preprocessed sources, by pattern rather than by properly parsing them
+
+const VERTEX_ENTRY_POINT = "vert_main"
+const FRAGMENT_ENTRY_POINT = "frag_main"
+
+type ShaderBinding struct {
+	group   uint32
+	binding uint32
+	name    string
+
+	address_space string // e.g. "uniform" or "storage, read", and empty for textures and samplers
+	type_name     string
+
+	visibility wgpu.ShaderStage // which entry points use it, directly or through the functions they call
+
+	declared string // where in the preprocessed source, like "wgsl:12:1", so errors can be pointed back at the original file
+}
+
+type ShaderVertexInput struct {
+	location  uint32
+	name      string
+	type_name string
+
+	declared string
+}
+
+type ShaderReflection struct {
+	bindings      []ShaderBinding
+	vertex_inputs []ShaderVertexInput
+}
+
+var WGSL_COMMENT = regexp.MustCompile(`(?s)//[^\n]*|/\*.*?\*/`)
+var WGSL_BINDING = regexp.MustCompile(`@group\((\d+)\)\s*@binding\((\d+)\)\s*var(?:<([^>]*)>)?\s+(\w+)\s*:\s*([^;]+);`)
+var WGSL_STRUCT = regexp.MustCompile(`struct\s+(\w+)\s*\{([^}]*)\}`)
+var WGSL_FUNCTION = regexp.MustCompile(`fn\s+(\w+)\s*\(`)
+var WGSL_LOCATION = regexp.MustCompile(`@location\((\d+)\)`)
+
+type wgslFunction struct {
+	params string
+	body   string
+
+	params_start int // offset of the parameters in the source
+}
+
+// lists of fields, with the offset of the list in the source
+
+type wgslStruct struct {
+	fields string
+	start  int
+}
+
+// errors are given at lines and columns of the preprocessed source, like WebGPU's, so ShaderSource.MapError can point them back at the original file
+
+func ReflectShader(code string) (*ShaderReflection, error) {
+	// comments are blanked out rather than removed, so offsets in the source stay the same
+
+	code = WGSL_COMMENT.ReplaceAllStringFunc(code, func(comment string) string {
+		return strings.Map(func(c rune) rune {
+			if c == '\n' {
+				return c
+			}
+
+			return ' '
+		}, comment)
+	})
+
+	reflection := &ShaderReflection{}
+
+	// structs, by name
+
+	structs := make(map[string]wgslStruct)
+
+	for _, match := range WGSL_STRUCT.FindAllStringSubmatchIndex(code, -1) {
+		structs[code[match[2]:match[3]]] = wgslStruct{fields: code[match[4]:match[5]], start: match[4]}
+	}
+
+	// functions, with their parameters and bodies, to find what each entry point uses
+
+	functions := make(map[string]wgslFunction)
+
+	for _, match := range WGSL_FUNCTION.FindAllStringSubmatchIndex(code, -1) {
+		name := code[match[2]:match[3]]
+
+		params_end := matchingBracket(code, match[1]-1)
+
+		if params_end < 0 {
+			return nil, fmt.Errorf("%s: can't find the end of function %s", wgslLocation(code, match[0]), name)
+		}
+
+		body_start := strings.IndexByte(code[params_end:], '{') + params_end
+		body_end := matchingBracket(code, body_start)
+
+		if body_start < params_end || body_end < 0 {
+			return nil, fmt.Errorf("%s: can't find the end of function %s", wgslLocation(code, match[0]), name)
+		}
+
+		functions[name] = wgslFunction{
+			params:       code[match[1] : params_end-1],
+			body:         code[params_end:body_end],
+			params_start: match[1],
+		}
+	}
+
+	vertex := usedIdentifiers(functions, VERTEX_ENTRY_POINT)
+	fragment := usedIdentifiers(functions, FRAGMENT_ENTRY_POINT)
+
+	for _, indices := range WGSL_BINDING.FindAllStringSubmatchIndex(code, -1) {
+		match := make([]string, len(indices)/2)
+
+		for i := range match {
+			if indices[2*i] >= 0 {
+				match[i] = code[indices[2*i]:indices[2*i+1]]
+			}
+		}
+
+		group, _ := strconv.ParseUint(match[1], 10, 32)
+		binding, _ := strconv.ParseUint(match[2], 10, 32)
+
+		reflected := ShaderBinding{
+			group:         uint32(group),
+			binding:       uint32(binding),
+			address_space: strings.TrimSpace(match[3]),
+			name:          match[4],
+			type_name:     strings.TrimSpace(match[5]),
+			declared:      wgslLocation(code, indices[0]),
+		}
+
+		if vertex[reflected.name] {
+			reflected.visibility |= wgpu.ShaderStage_Vertex
+		}
+
+		if fragment[reflected.name] {
+			reflected.visibility |= wgpu.ShaderStage_Fragment
+		}
+
+		reflection.bindings = append(reflection.bindings, reflected)
+	}
+
+	// vertex inputs are parameters with locations, or fields with locations of parameters which are structs
+
+	if vert_main, ok := functions[VERTEX_ENTRY_POINT]; ok {
+		for _, param := range splitWgslList(vert_main.params) {
+			_, type_name := splitWgslDeclaration(param)
+
+			if fields, ok := structs[type_name]; ok {
+				for _, field := range splitWgslList(fields.fields) {
+					reflection.addVertexInput(field, wgslLocation(code, fields.start+strings.Index(fields.fields, field)))
+				}
+
+				continue
+			}
+
+			reflection.addVertexInput(param, wgslLocation(code, vert_main.params_start+strings.Index(vert_main.params, param)))
+		}
+	}
+
+	sort.Slice(reflection.bindings, func(i, j int) bool {
+		a, b := reflection.bindings[i], reflection.bindings[j]
+
+		if a.group != b.group {
+			return a.group < b.group
+		}
+
+		return a.binding < b.binding
+	})
+
+	return reflection, nil
+}
+
+func (reflection *ShaderReflection) addVertexInput(declaration, declared string) {
+	location := WGSL_LOCATION.FindStringSubmatch(declaration)
+
+	if location == nil {
+		return // builtins, like the vertex index
+	}
+
+	index, _ := strconv.ParseUint(location[1], 10, 32)
+	name, type_name := splitWgslDeclaration(declaration)
+
+	reflection.vertex_inputs = append(reflection.vertex_inputs, ShaderVertexInput{
+		location:  uint32(index),
+		name:      name,
+		type_name: type_name,
+		declared:  declared,
+	})
+}
+
+// line and column of an offset in the source, in the same form as WebGPU's errors
+
+func wgslLocation(code string, offset int) string {
+	line := strings.Count(code[:offset], "\n") + 1
+	column := offset - strings.LastIndexByte(code[:offset], '\n')
+
+	return fmt.Sprintf("wgsl:%d:%d", line, column)
+}
+
+// index just past the bracket closing the one at open, or -1
+
+func matchingBracket(code string, open int) int {
+	depth := 0
+
+	for i := open; i < len(code); i++ {
+		switch code[i] {
+		case '(', '{':
+			depth++
+		case ')', '}':
+			depth--
+
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return -1
+}
+
+// split a comma-separated list, e.g. parameters or struct fields, ignoring commas nested in brackets like array<Light, 16>
+
+func splitWgslList(list string) []string {
+	var items []string
+	depth := 0
+	start := 0
+
+	for i, c := range list {
+		switch c {
+		case '(', '<', '[':
+			depth++
+		case ')', '>', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	items = append(items, list[start:])
+
+	var trimmed []string
+
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			trimmed = append(trimmed, item)
+		}
+	}
+
+	return trimmed
+}
+
+// name and type of something like "@location(0) pos: vec3f"
+
+func splitWgslDeclaration(declaration string) (string, string) {
+	colon := strings.IndexByte(declaration, ':')
+
+	if colon < 0 {
+		return "", ""
+	}
+
+	names := strings.Fields(declaration[:colon])
+
+	if len(names) == 0 {
+		return "", strings.TrimSpace(declaration[colon+1:])
+	}
+
+	return names[len(names)-1], strings.TrimSpace(declaration[colon+1:])
+}
+
+// identifiers an entry point uses, including those used by the functions it calls
+
+func usedIdentifiers(functions map[string]wgslFunction, entry_point string) map[string]bool {
+	used := make(map[string]bool)
+	visited := make(map[string]bool)
+	pending := []string{entry_point}
+
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		function, ok := functions[name]
+
+		if !ok || visited[name] {
+			continue
+		}
+
+		visited[name] = true
+
+		for _, identifier := range SHADER_IDENTIFIER.FindAllString(function.params+function.body, -1) {
+			used[identifier] = true
+
+			if _, ok := functions[identifier]; ok {
+				pending = append(pending, identifier)
+			}
+		}
+	}
+
+	return used
+}
+
+// layout entry the binding needs, visible to unused_visibility if no entry point uses it
+
+func (binding ShaderBinding) LayoutEntry(unused_visibility wgpu.ShaderStage) (wgpu.BindGroupLayoutEntry, error) {
+	entry := wgpu.BindGroupLayoutEntry{
+		Binding:    binding.binding,
+		Visibility: binding.visibility,
+	}
+
+	if entry.Visibility == wgpu.ShaderStage_None {
+		entry.Visibility = unused_visibility
+	}
+
+	switch strings.ReplaceAll(binding.address_space, " ", "") {
+	case "uniform":
+		entry.Buffer.Type = wgpu.BufferBindingType_Uniform
+		return entry, nil
+
+	case "storage", "storage,read":
+		entry.Buffer.Type = wgpu.BufferBindingType_ReadOnlyStorage
+		return entry, nil
+
+	case "storage,read_write":
+		entry.Buffer.Type = wgpu.BufferBindingType_Storage
+		return entry, nil
+
+	case "":
+		break
+
+	default:
+		return entry, fmt.Errorf("%s: %s has an unsupported address space: %s", binding.declared, binding.name, binding.address_space)
+	}
+
+	type_name := strings.ReplaceAll(binding.type_name, " ", "")
+
+	switch type_name {
+	case "sampler":
+		entry.Sampler.Type = wgpu.SamplerBindingType_Filtering
+		return entry, nil
+
+	case "sampler_comparison":
+		entry.Sampler.Type = wgpu.SamplerBindingType_Comparison
+		return entry, nil
+	}
+
+	// textures, e.g. texture_2d<f32> or texture_depth_2d_array
+
+	kind, sample, _ := strings.Cut(strings.TrimPrefix(type_name, "texture_"), "<")
+	sample = strings.TrimSuffix(sample, ">")
+
+	if !strings.HasPrefix(type_name, "texture_") {
+		return entry, fmt.Errorf("%s: %s has an unsupported type: %s", binding.declared, binding.name, binding.type_name)
+	}
+
+	if strings.HasPrefix(kind, "depth_") {
+		kind = strings.TrimPrefix(kind, "depth_")
+		sample = "depth"
+	}
+
+	dimensions := map[string]wgpu.TextureViewDimension{
+		"1d":         wgpu.TextureViewDimension_1D,
+		"2d":         wgpu.TextureViewDimension_2D,
+		"2d_array":   wgpu.TextureViewDimension_2DArray,
+		"3d":         wgpu.TextureViewDimension_3D,
+		"cube":       wgpu.TextureViewDimension_Cube,
+		"cube_array": wgpu.TextureViewDimension_CubeArray,
+	}
+
+	sample_types := map[string]wgpu.TextureSampleType{
+		"f32":   wgpu.TextureSampleType_Float,
+		"i32":   wgpu.TextureSampleType_Sint,
+		"u32":   wgpu.TextureSampleType_Uint,
+		"depth": wgpu.TextureSampleType_Depth,
+	}
+
+	dimension, ok := dimensions[kind]
+	sample_type, sample_ok := sample_types[sample]
+
+	if !ok || !sample_ok {
+		return entry, fmt.Errorf("%s: %s has an unsupported type: %s", binding.declared, binding.name, binding.type_name)
+	}
+
+	entry.Texture = wgpu.TextureBindingLayout{
+		ViewDimension: dimension,
+		SampleType:    sample_type,
+	}
+
+	return entry, nil
+}
+
+// layout entries for every binding of a group
+
+func (reflection *ShaderReflection) Layout(group uint32, unused_visibility wgpu.ShaderStage) ([]wgpu.BindGroupLayoutEntry, error) {
+	entries := []wgpu.BindGroupLayoutEntry{}
+
+	for _, binding := range reflection.bindings {
+		if binding.group != group {
+			continue
+		}
+
+		entry, err := binding.LayoutEntry(unused_visibility)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// every binding of a group must be in the layout made elsewhere, of the same kind and visible to every entry point using it
+
+func (reflection *ShaderReflection) CheckLayout(group uint32, entries []wgpu.BindGroupLayoutEntry) error {
+	for _, binding := range reflection.bindings {
+		if binding.group != group {
+			continue
+		}
+
+		expected, err := binding.LayoutEntry(binding.visibility)
+		if err != nil {
+			return err
+		}
+
+		var actual *wgpu.BindGroupLayoutEntry
+
+		for i := range entries {
+			if entries[i].Binding == binding.binding {
+				actual = &entries[i]
+			}
+		}
+
+		where := fmt.Sprintf("%s: %s (@group(%d) @binding(%d))", binding.declared, binding.name, binding.group, binding.binding)
+
+		switch {
+		case actual == nil:
+			return fmt.Errorf("%s isn't in the layout", where)
+
+		case actual.Visibility&expected.Visibility != expected.Visibility:
+			return fmt.Errorf("%s isn't visible to every entry point using it", where)
+
+		case actual.Buffer.Type != expected.Buffer.Type:
+			return fmt.Errorf("%s should be a buffer of type %v, not %v", where, expected.Buffer.Type, actual.Buffer.Type)
+
+		case actual.Sampler.Type != expected.Sampler.Type:
+			return fmt.Errorf("%s should be a sampler of type %v, not %v", where, expected.Sampler.Type, actual.Sampler.Type)
+
+		case actual.Texture.ViewDimension != expected.Texture.ViewDimension:
+			return fmt.Errorf("%s should be a texture of dimension %v, not %v", where, expected.Texture.ViewDimension, actual.Texture.ViewDimension)
+
+		case actual.Texture.SampleType != expected.Texture.SampleType &&
+			!(expected.Texture.SampleType == wgpu.TextureSampleType_Float && actual.Texture.SampleType == wgpu.TextureSampleType_UnfilterableFloat):
+			return fmt.Errorf("%s should be a texture of sample type %v, not %v", where, expected.Texture.SampleType, actual.Texture.SampleType)
+		}
+	}
+
+	return nil
+}
+
+// groups the shader has bindings in
+
+func (reflection *ShaderReflection) Groups() []uint32 {
+	var groups []uint32
+
+	for _, binding := range reflection.bindings {
+		if len(groups) == 0 || groups[len(groups)-1] != binding.group {
+			groups = append(groups, binding.group)
+		}
+	}
+
+	return groups
+}
+
+var WGSL_VERTEX_FORMATS = map[string]wgpu.VertexFormat{
+	"f32":       wgpu.VertexFormat_Float32,
+	"vec2f":     wgpu.VertexFormat_Float32x2,
+	"vec2<f32>": wgpu.VertexFormat_Float32x2,
+	"vec3f":     wgpu.VertexFormat_Float32x3,
+	"vec3<f32>": wgpu.VertexFormat_Float32x3,
+	"vec4f":     wgpu.VertexFormat_Float32x4,
+	"vec4<f32>": wgpu.VertexFormat_Float32x4,
+	"u32":       wgpu.VertexFormat_Uint32,
+	"vec2u":     wgpu.VertexFormat_Uint32x2,
+	"vec2<u32>": wgpu.VertexFormat_Uint32x2,
+	"vec3u":     wgpu.VertexFormat_Uint32x3,
+	"vec3<u32>": wgpu.VertexFormat_Uint32x3,
+	"vec4u":     wgpu.VertexFormat_Uint32x4,
+	"vec4<u32>": wgpu.VertexFormat_Uint32x4,
+	"i32":       wgpu.VertexFormat_Sint32,
+	"vec2i":     wgpu.VertexFormat_Sint32x2,
+	"vec2<i32>": wgpu.VertexFormat_Sint32x2,
+	"vec3i":     wgpu.VertexFormat_Sint32x3,
+	"vec3<i32>": wgpu.VertexFormat_Sint32x3,
+	"vec4i":     wgpu.VertexFormat_Sint32x4,
+	"vec4<i32>": wgpu.VertexFormat_Sint32x4,
+}
+
+// every vertex input must come from an attribute of one of the vertex buffers, of the same format
+
+func (reflection *ShaderReflection) CheckVertexBuffers(layouts []wgpu.VertexBufferLayout) error {
+	for _, input := range reflection.vertex_inputs {
+		var attribute *wgpu.VertexAttribute
+
+		for i := range layouts {
+			for j := range layouts[i].Attributes {
+				if layouts[i].Attributes[j].ShaderLocation == input.location {
+					attribute = &layouts[i].Attributes[j]
+				}
+			}
+		}
+
+		where := fmt.Sprintf("%s: vertex input %s (@location(%d))", input.declared, input.name, input.location)
+		format, known := WGSL_VERTEX_FORMATS[strings.ReplaceAll(input.type_name, " ", "")]
+
+		switch {
+		case attribute == nil:
+			return fmt.Errorf("%s isn't in any vertex buffer", where)
+
+		case known && attribute.Format != format:
+			return fmt.Errorf("%s should be %v, not %v", where, format, attribute.Format)
+		}
+	}
+
+	return nil
+}
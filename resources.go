@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// GPU resources shared between everything which asks for the same thing, e.g. every model using the same lightmap
+// they're keyed by their contents (or by their options for samplers), and only released once nothing references them anymore
+
+type CachedResource struct {
+	kind  string
+	label string // of whoever asked for it first
+	refs  int
+
+	value   any
+	release func()
+}
+
+type ResourceCache struct {
+	state *State
+
+	resources map[string]*CachedResource
+	keys      map[any]string // from what was handed out back to its key
+}
+
+func NewResourceCache(state *State) *ResourceCache {
+	return &ResourceCache{
+		state:     state,
+		resources: make(map[string]*CachedResource),
+		keys:      make(map[any]string),
+	}
+}
+
+func contentKey(kind string, content []byte, extra ...any) string {
+	hash := sha1.Sum(content)
+	return fmt.Sprintf("%s:%s:%v", kind, hex.EncodeToString(hash[:]), extra)
+}
+
+// take a reference to a resource, creating it if it isn't cached yet
+
+func (cache *ResourceCache) get(key, kind, label string, create func() (any, func(), error)) (any, error) {
+	if resource, ok := cache.resources[key]; ok {
+		resource.refs++
+		return resource.value, nil
+	}
+
+	value, release, err := create()
+	if err != nil {
+		return nil, err
+	}
+
+	cache.resources[key] = &CachedResource{
+		kind:    kind,
+		label:   label,
+		refs:    1,
+		value:   value,
+		release: release,
+	}
+
+	cache.keys[value] = key
+	return value, nil
+}
+
+func (cache *ResourceCache) Texture(label string, buf []byte, options TextureOptions) (*Texture, error) {
+	value, err := cache.get(contentKey("texture", buf, options), "texture", label, func() (any, func(), error) {
+		texture, err := NewTextureFromBytesWithOptions(cache.state, label, buf, options)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return texture, texture.Release, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*Texture), nil
+}
+
+func (cache *ResourceCache) Sampler(options TextureOptions) (*wgpu.Sampler, error) {
+	key := fmt.Sprintf("sampler:%v", options)
+
+	value, err := cache.get(key, "sampler", key, func() (any, func(), error) {
+		sampler, err := NewSampler(cache.state, options)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return sampler, sampler.Release, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*wgpu.Sampler), nil
+}
+
+// permutations of the same shader are cached separately, by their defines
+
+func (cache *ResourceCache) Shader(name string, defines ShaderDefines) (*Shader, error) {
+	label := fmt.Sprintf("%s (%s)", name, defines.Key())
+
+	value, err := cache.get("shader:"+label, "shader", label, func() (any, func(), error) {
+		shader, err := NewShader(cache.state, name, defines)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return shader, shader.Release, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*Shader), nil
+}
+
+// pipelines are keyed by a hash of their descriptor, so everything asking for the same state gets the same pipeline
+
+func (cache *ResourceCache) Pipeline(descriptor PipelineDescriptor) (*Pipeline, error) {
+	value, err := cache.get(contentKey("pipeline", []byte(descriptor.Key())), "pipeline", descriptor.label, func() (any, func(), error) {
+		pipeline, err := NewPipeline(cache.state, descriptor)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return pipeline, pipeline.Release, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*Pipeline), nil
+}
+
+func (cache *ResourceCache) Material(name string) (*Material, error) {
+	value, err := cache.get("material:"+name, "material", name, func() (any, func(), error) {
+		material, err := NewMaterial(cache.state, name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return material, material.Release, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*Material), nil
+}
+
+func (cache *ResourceCache) Buffer(label string, contents []byte, usage wgpu.BufferUsage) (*wgpu.Buffer, error) {
+	value, err := cache.get(contentKey("buffer", contents, usage), "buffer", label, func() (any, func(), error) {
+		buffer, err := cache.state.device.CreateBufferInit(&wgpu.BufferInitDescriptor{
+			Label:    label,
+			Contents: contents,
+			Usage:    usage,
+		})
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return buffer, buffer.Release, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*wgpu.Buffer), nil
+}
+
+// drop a reference to something the cache handed out
+
+func (cache *ResourceCache) Release(value any) {
+	key, ok := cache.keys[value]
+	if !ok {
+		log.Printf("Releasing a resource which isn't cached: %T\n", value)
+		return
+	}
+
+	resource := cache.resources[key]
+	resource.refs--
+
+	if resource.refs > 0 {
+		return
+	}
+
+	delete(cache.resources, key)
+	delete(cache.keys, value)
+	resource.release()
+}
+
+// anything still referenced at shutdown has leaked, so log it before releasing it anyway
+// materials go first, as they hold references to everything else, and then textures and pipelines, as they hold references to samplers and shaders
+
+func (cache *ResourceCache) Shutdown() {
+	var keys []string
+
+	for key := range cache.resources {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := cache.resources[keys[i]], cache.resources[keys[j]]
+
+		if a_rank, b_rank := shutdownRank(a.kind), shutdownRank(b.kind); a_rank != b_rank {
+			return a_rank < b_rank
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		resource, ok := cache.resources[key]
+		if !ok {
+			continue // released along with something holding a reference to it
+		}
+
+		log.Printf("Leaked %s %q (%d references)\n", resource.kind, resource.label, resource.refs)
+
+		resource.refs = 1
+		cache.Release(resource.value)
+	}
+}
+
+func shutdownRank(kind string) int {
+	switch kind {
+	case "material":
+		return 0
+	case "texture", "pipeline":
+		return 1
+	}
+
+	return 2
+}
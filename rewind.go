@@ -0,0 +1,276 @@
+package main
+
+import (
+	"log"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// the last few seconds are recorded, so that holding the rewind key plays them back in reverse
+// the longer the key is held, the faster time goes back
+
+const REWIND_KEY = glfw.KeyR
+const REWIND_HISTORY = 10       // seconds
+const REWIND_INTERVAL = 1. / 30 // seconds between snapshots
+const REWIND_MIN_SPEED = 1
+const REWIND_MAX_SPEED = 4
+const REWIND_ACCEL = 1 // speed gained per second the key is held
+
+// worlds with state of their own which isn't in their scene graph
+
+type RewindWorld interface {
+	Snapshot() any
+	Restore(snapshot any)
+}
+
+type NodeSnapshot struct {
+	local  [4][4]float32
+	hidden bool
+	ignore []bool // whether each collider is ignored, as triggers turn them off
+}
+
+type EntitySnapshot struct {
+	pos         [3]float32
+	rot         [2]float32
+	vel         [3]float32
+	orientation [4][4]float32
+	grounded    bool
+}
+
+type Snapshot struct {
+	time   float32
+	player EntitySnapshot
+	nodes  map[string]NodeSnapshot
+	flags  Flags
+	active []string
+	worlds map[string]any
+
+	// trigger state which isn't kept in the flags
+
+	not_touching_apat bool
+}
+
+type Rewind struct {
+	state *State
+
+	// ring buffer of snapshots, oldest first
+
+	snapshots []*Snapshot
+	head      int
+	count     int
+
+	time      float32
+	rewinding bool
+	speed     float32
+}
+
+func NewRewind(state *State) *Rewind {
+	return &Rewind{
+		state:     state,
+		snapshots: make([]*Snapshot, int(REWIND_HISTORY/REWIND_INTERVAL)),
+	}
+}
+
+func (rewind *Rewind) Rewinding() bool {
+	return rewind.rewinding
+}
+
+func (rewind *Rewind) last() *Snapshot {
+	if rewind.count == 0 {
+		return nil
+	}
+
+	return rewind.snapshots[(rewind.head+rewind.count-1)%len(rewind.snapshots)]
+}
+
+func (rewind *Rewind) push(snapshot *Snapshot) {
+	if rewind.count < len(rewind.snapshots) {
+		rewind.snapshots[(rewind.head+rewind.count)%len(rewind.snapshots)] = snapshot
+		rewind.count++
+		return
+	}
+
+	// full, so the oldest snapshot is overwritten
+
+	rewind.snapshots[rewind.head] = snapshot
+	rewind.head = (rewind.head + 1) % len(rewind.snapshots)
+}
+
+func (rewind *Rewind) pop() {
+	rewind.count--
+	rewind.snapshots[(rewind.head+rewind.count)%len(rewind.snapshots)] = nil
+}
+
+func (rewind *Rewind) record() *Snapshot {
+	state := rewind.state
+	player := state.player
+
+	snapshot := &Snapshot{
+		time: rewind.time,
+		player: EntitySnapshot{
+			pos:         player.pos,
+			rot:         player.rot,
+			vel:         player.vel,
+			orientation: player.orientation.Data,
+			grounded:    player.grounded,
+		},
+		nodes:  make(map[string]NodeSnapshot, len(state.nodes)),
+		flags:  make(Flags, len(state.flags)),
+		active: state.worlds.Active(),
+		worlds: make(map[string]any),
+
+		not_touching_apat: not_touching_apat,
+	}
+
+	for name, node := range state.nodes {
+		node_snapshot := NodeSnapshot{
+			local:  node.local.Data,
+			hidden: node.hidden,
+		}
+
+		for _, collider := range node.colliders {
+			node_snapshot.ignore = append(node_snapshot.ignore, collider.ignore)
+		}
+
+		snapshot.nodes[name] = node_snapshot
+	}
+
+	for flag, value := range state.flags {
+		snapshot.flags[flag] = value
+	}
+
+	for _, name := range world_names {
+		if world, ok := state.worlds.Get(name).(RewindWorld); ok {
+			snapshot.worlds[name] = world.Snapshot()
+		}
+	}
+
+	return snapshot
+}
+
+func (rewind *Rewind) restore(snapshot *Snapshot) {
+	state := rewind.state
+	player := state.player
+
+	player.pos = snapshot.player.pos
+	player.rot = snapshot.player.rot
+	player.vel = snapshot.player.vel
+	player.orientation.Data = snapshot.player.orientation
+	player.grounded = snapshot.player.grounded
+	player.acc = [3]float32{}
+
+	for name, node_snapshot := range snapshot.nodes {
+		node, ok := state.nodes[name]
+
+		if !ok {
+			continue
+		}
+
+		node.SetLocal(&Mat{Data: node_snapshot.local})
+		node.hidden = node_snapshot.hidden
+
+		for i := range node.colliders {
+			if i < len(node_snapshot.ignore) {
+				node.colliders[i].ignore = node_snapshot.ignore[i]
+			}
+		}
+	}
+
+	for flag := range state.flags {
+		delete(state.flags, flag)
+	}
+
+	for flag, value := range snapshot.flags {
+		state.flags[flag] = value
+	}
+
+	// worlds are switched silently, as otherwise going back into a world would replay what happens when it's entered, e.g. the outro
+
+	state.worlds.RestoreActive(snapshot.active)
+
+	for name, world_snapshot := range snapshot.worlds {
+		if world, ok := state.worlds.Get(name).(RewindWorld); ok {
+			world.Restore(world_snapshot)
+		}
+	}
+
+	not_touching_apat = snapshot.not_touching_apat
+}
+
+// cutscenes can't be rewound; they're as good as a checkpoint
+
+func (rewind *Rewind) start() {
+	if rewind.state.timeline.Playing() || rewind.count == 0 {
+		return
+	}
+
+	log.Println("Start rewinding")
+
+	rewind.rewinding = true
+	rewind.speed = REWIND_MIN_SPEED
+
+	// what was being said is no longer being said
+
+	rewind.state.dialogue.Stop()
+
+	if soundSystem != nil {
+		soundSystem.Stop()
+	}
+
+	rewind.state.events.Emit("rewind_start")
+}
+
+func (rewind *Rewind) stop() {
+	log.Println("Stop rewinding")
+
+	rewind.rewinding = false
+
+	// the mouse kept moving while time went back, which shouldn't turn the camera once it's handed back
+
+	width, height := rewind.state.win.GetSize()
+	rewind.state.win.SetCursorPos(float64(width)/2, float64(height)/2)
+
+	rewind.state.events.Emit("rewind_stop")
+}
+
+func (rewind *Rewind) Update() {
+	held := rewind.state.win.GetKey(REWIND_KEY) == glfw.Press
+
+	if held && !rewind.rewinding {
+		rewind.start()
+	} else if !held && rewind.rewinding {
+		rewind.stop()
+	}
+
+	if !rewind.rewinding {
+		rewind.time += rewind.state.dt
+
+		if last := rewind.last(); last == nil || rewind.time-last.time >= REWIND_INTERVAL {
+			rewind.push(rewind.record())
+		}
+
+		return
+	}
+
+	rewind.speed += REWIND_ACCEL * rewind.state.dt
+
+	if rewind.speed > REWIND_MAX_SPEED {
+		rewind.speed = REWIND_MAX_SPEED
+	}
+
+	rewind.time -= rewind.state.dt * rewind.speed
+
+	// drop snapshots from the future, always keeping the oldest one to rewind to
+
+	for rewind.count > 1 && rewind.last().time > rewind.time {
+		rewind.pop()
+	}
+
+	last := rewind.last()
+
+	if rewind.time < last.time {
+		rewind.time = last.time
+	}
+
+	rewind.restore(last)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"log"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// node in a world's scene graph
+// the model and colliders attached to a node follow its world transform, i.e. its local transform on top of its parent's
+
+type SceneNode struct {
+	state *State
+	name  string
+
+	parent   *SceneNode
+	children []*SceneNode
+
+	local *Mat
+	world *Mat
+	dirty bool
+
+	hidden bool
+
+	model     *Model
+	instances *Instances // if set, the model is drawn once per instance
+
+	colliders       []Collider // in the node's space
+	world_colliders []Collider
+}
+
+func NewSceneNode(state *State, name string, parent *SceneNode) *SceneNode {
+	node := &SceneNode{
+		state: state,
+		name:  name,
+		local: NewMat(),
+		world: NewMat(),
+		dirty: true,
+	}
+
+	if parent != nil {
+		parent.AddChild(node)
+	}
+
+	if name != "" {
+		state.nodes[name] = node
+	}
+
+	return node
+}
+
+func (node *SceneNode) AddChild(child *SceneNode) {
+	if child.parent != nil {
+		child.parent.RemoveChild(child)
+	}
+
+	child.parent = node
+	node.children = append(node.children, child)
+	child.invalidate()
+}
+
+func (node *SceneNode) RemoveChild(child *SceneNode) {
+	for i, other := range node.children {
+		if other == child {
+			node.children = append(node.children[:i], node.children[i+1:]...)
+			child.parent = nil
+			child.invalidate()
+			return
+		}
+	}
+}
+
+// a clean node always has clean ancestors, so if a node is already dirty, so are its descendants
+
+func (node *SceneNode) invalidate() {
+	if node.dirty {
+		return
+	}
+
+	node.dirty = true
+
+	for _, child := range node.children {
+		child.invalidate()
+	}
+}
+
+func (node *SceneNode) SetLocal(local *Mat) *SceneNode {
+	node.local.Data = local.Data
+	node.invalidate()
+
+	return node
+}
+
+func (node *SceneNode) Local() *Mat {
+	return node.local
+}
+
+func (node *SceneNode) World() *Mat {
+	if !node.dirty {
+		return node.world
+	}
+
+	if node.parent != nil {
+		node.world.Data = node.parent.World().Data
+	} else {
+		node.world.Identity()
+	}
+
+	node.world.Multiply(node.local)
+	node.updateColliders()
+	node.dirty = false
+
+	return node.world
+}
+
+// a node is only drawn if none of its ancestors are hidden
+
+func (node *SceneNode) Visible() bool {
+	for ; node != nil; node = node.parent {
+		if node.hidden {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (node *SceneNode) SetModel(model *Model) *SceneNode {
+	node.model = model
+	return node
+}
+
+func (node *SceneNode) SetInstances(instances *Instances) *SceneNode {
+	node.instances = instances
+	return node
+}
+
+func (node *SceneNode) AddColliders(colliders []Collider) *SceneNode {
+	node.colliders = append(node.colliders, colliders...)
+	node.world_colliders = make([]Collider, len(node.colliders))
+	node.invalidate()
+
+	return node
+}
+
+// colliders are axis-aligned, so rotated nodes get the bounding box of their rotated colliders
+
+func (node *SceneNode) updateColliders() {
+	for i := range node.colliders {
+		local := &node.colliders[i]
+		world := &node.world_colliders[i]
+
+		*world = *local
+
+		for j := 0; j < 8; j++ {
+			corner := local.position1
+
+			if j&1 != 0 {
+				corner[0] = local.position2[0]
+			}
+
+			if j&2 != 0 {
+				corner[1] = local.position2[1]
+			}
+
+			if j&4 != 0 {
+				corner[2] = local.position2[2]
+			}
+
+			corner = node.world.TransformPoint(corner)
+
+			if j == 0 {
+				world.position1 = corner
+				world.position2 = corner
+				continue
+			}
+
+			for k := 0; k < 3; k++ {
+				if corner[k] < world.position1[k] {
+					world.position1[k] = corner[k]
+				}
+
+				if corner[k] > world.position2[k] {
+					world.position2[k] = corner[k]
+				}
+			}
+		}
+	}
+}
+
+// colliders in world space
+// these are recomputed whenever the node moves, so changes like ignore must be made on node.colliders
+
+func (node *SceneNode) WorldColliders() []Collider {
+	node.World()
+	return node.world_colliders
+}
+
+func (node *SceneNode) Walk(fn func(node *SceneNode)) {
+	fn(node)
+
+	for _, child := range node.children {
+		child.Walk(fn)
+	}
+}
+
+type SceneDraw struct {
+	model     *Model
+	instances *Instances
+	offset    uint32 // of the model matrix in the uniform ring
+}
+
+// visible models in the graph which aren't entirely outside the frustum, each with its transform pushed to the uniform ring
+// culled models are counted in the frame's stats
+
+func (node *SceneNode) collect(frustum *Frustum) []SceneDraw {
+	state := node.state
+	var draws []SceneDraw
+
+	node.Walk(func(node *SceneNode) {
+		if node.model == nil || !node.Visible() {
+			return
+		}
+
+		if node.instances != nil {
+			if node.instances.Count() == 0 {
+				return
+			}
+
+			if err := node.instances.upload(); err != nil {
+				log.Printf("Can't upload instances of %q: %v\n", node.name, err)
+				return
+			}
+		}
+
+		transform := ModelMatrix(node.World())
+		state.frame.stats.draws++
+
+		if !node.modelVisible(frustum, transform) {
+			state.frame.stats.culled++
+			return
+		}
+
+		draws = append(draws, SceneDraw{
+			model:     node.model,
+			instances: node.instances,
+			offset:    state.uniforms.Push(transform),
+		})
+	})
+
+	return draws
+}
+
+// draw every visible model in the graph in a single pass, each with its own transform
+// if clear is set, the pass clears the colour and depth attachments
+
+func (node *SceneNode) Render(clear bool) {
+	load_op := wgpu.LoadOp_Load
+
+	if clear {
+		load_op = wgpu.LoadOp_Clear
+	}
+
+	state := node.state
+	vp := state.player.ViewProjection()
+	camera := state.uniforms.Push(vp)
+	draws := node.collect(NewFrustum(vp))
+
+	// the view matrix's inverse takes the origin to wherever the camera is
+
+	state.lights.Upload(state.player.v.Copy().Inverse().TransformPoint([3]float32{0, 0, 0}))
+
+	state.frame.Pass(node.name, load_op, load_op, func(render_pass *wgpu.RenderPassEncoder) {
+		for _, draw := range draws {
+			draw.model.Draw(render_pass, camera, draw.offset, draw.instances)
+		}
+	}).Reads(state.lights.shadows.layers...)
+}
+
+// draw the depth of every visible model in the graph as seen with vp, into the current depth target only
+// the depth target is always cleared first
+
+func (node *SceneNode) RenderDepth(name string, vp *Mat) {
+	state := node.state
+	camera := state.uniforms.Push(vp)
+	draws := node.collect(NewFrustum(vp))
+
+	state.frame.Pass(name, wgpu.LoadOp_Load, wgpu.LoadOp_Clear, func(render_pass *wgpu.RenderPassEncoder) {
+		for _, draw := range draws {
+			draw.model.DrawDepth(render_pass, camera, draw.offset, draw.instances)
+		}
+	})
+}
+
+// instanced models are drawn as long as any one of their instances is visible
+
+func (node *SceneNode) modelVisible(frustum *Frustum, transform *Mat) bool {
+	if node.instances == nil {
+		return frustum.ModelVisible(node.model, transform)
+	}
+
+	for _, instance := range node.instances.data {
+		if frustum.ModelVisible(node.model, transform.Copy().Multiply(&Mat{Data: instance.transform})) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (node *SceneNode) ReleaseModels() {
+	node.Walk(func(node *SceneNode) {
+		if node.model != nil {
+			node.model.Release()
+		}
+
+		if node.instances != nil {
+			node.instances.Release()
+		}
+	})
+}
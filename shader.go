@@ -0,0 +1,289 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+//go:embed shaders
+var shaders_fs embed.FS
+
+// shaders go through a small preprocessor before being handed to WebGPU, which understands:
+// #include "path" - paste in another file, relative to shaders/ (each file is only ever included once per shader)
+// #define NAME [value] and #undef NAME - from then on, NAME is replaced by value wherever it's a whole word
+// #ifdef NAME, #ifndef NAME, #else and #endif - only keep what's between them if NAME is (or isn't) defined
+// defines can also be passed in, which is how permutations of the same shader are made, e.g. with or without fog
+
+type ShaderDefines map[string]string
+
+// canonical form of a set of defines, used to tell permutations apart
+
+func (defines ShaderDefines) Key() string {
+	var names []string
+
+	for name := range defines {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for i, name := range names {
+		if value := defines[name]; value != "" {
+			names[i] = name + "=" + value
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// where each line of the preprocessed source came from, to point errors at the original files
+
+type ShaderLine struct {
+	file string
+	line int
+}
+
+type ShaderSource struct {
+	code  string
+	lines []ShaderLine
+}
+
+type shaderPreprocessor struct {
+	defines  ShaderDefines
+	included map[string]bool
+
+	out   strings.Builder
+	lines []ShaderLine
+}
+
+type shaderCondition struct {
+	active  bool // whether what's in the current branch is kept
+	parent  bool // whether what's around the condition is kept
+	in_else bool
+	line    ShaderLine
+}
+
+func PreprocessShader(name string, defines ShaderDefines) (*ShaderSource, error) {
+	preprocessor := &shaderPreprocessor{
+		defines:  make(ShaderDefines),
+		included: make(map[string]bool),
+	}
+
+	for name, value := range defines {
+		preprocessor.defines[name] = value
+	}
+
+	if err := preprocessor.file(name); err != nil {
+		return nil, err
+	}
+
+	return &ShaderSource{
+		code:  preprocessor.out.String(),
+		lines: preprocessor.lines,
+	}, nil
+}
+
+func (preprocessor *shaderPreprocessor) file(name string) error {
+	name = path.Clean(name)
+
+	if preprocessor.included[name] {
+		return nil
+	}
+
+	preprocessor.included[name] = true
+
+	src, err := shaders_fs.ReadFile("shaders/" + name)
+	if err != nil {
+		return err
+	}
+
+	var conditions []*shaderCondition
+	active := true
+
+	for i, line := range strings.Split(strings.TrimSuffix(string(src), "\n"), "\n") {
+		here := ShaderLine{file: "shaders/" + name, line: i + 1}
+		trimmed := strings.TrimSpace(line)
+
+		if !strings.HasPrefix(trimmed, "#") {
+			if active {
+				preprocessor.emit(preprocessor.substitute(line), here)
+			}
+
+			continue
+		}
+
+		fields := strings.Fields(trimmed)
+		directive := fields[0]
+		args := fields[1:]
+
+		switch directive {
+		case "#ifdef", "#ifndef":
+			if len(args) != 1 {
+				return fmt.Errorf("%s: %s takes a single name", here, directive)
+			}
+
+			_, defined := preprocessor.defines[args[0]]
+
+			conditions = append(conditions, &shaderCondition{
+				active: active && defined == (directive == "#ifdef"),
+				parent: active,
+				line:   here,
+			})
+
+		case "#else":
+			if len(conditions) == 0 {
+				return fmt.Errorf("%s: #else without #ifdef", here)
+			}
+
+			condition := conditions[len(conditions)-1]
+
+			if condition.in_else {
+				return fmt.Errorf("%s: second #else for the #ifdef at %s", here, condition.line)
+			}
+
+			condition.in_else = true
+			condition.active = condition.parent && !condition.active
+
+		case "#endif":
+			if len(conditions) == 0 {
+				return fmt.Errorf("%s: #endif without #ifdef", here)
+			}
+
+			conditions = conditions[:len(conditions)-1]
+
+		case "#define":
+			if len(args) == 0 {
+				return fmt.Errorf("%s: #define without a name", here)
+			}
+
+			if active {
+				preprocessor.defines[args[0]] = strings.Join(args[1:], " ")
+			}
+
+		case "#undef":
+			if len(args) != 1 {
+				return fmt.Errorf("%s: #undef takes a single name", here)
+			}
+
+			if active {
+				delete(preprocessor.defines, args[0])
+			}
+
+		case "#include":
+			if !active {
+				break
+			}
+
+			included, err := strconv.Unquote(strings.TrimSpace(strings.TrimPrefix(trimmed, "#include")))
+			if err != nil {
+				return fmt.Errorf("%s: #include needs a quoted path", here)
+			}
+
+			if err := preprocessor.file(included); err != nil {
+				return fmt.Errorf("%s: %w", here, err)
+			}
+
+		default:
+			return fmt.Errorf("%s: unknown directive %s", here, directive)
+		}
+
+		active = len(conditions) == 0 || conditions[len(conditions)-1].active
+	}
+
+	if len(conditions) > 0 {
+		return fmt.Errorf("%s: #ifdef without #endif", conditions[len(conditions)-1].line)
+	}
+
+	return nil
+}
+
+func (preprocessor *shaderPreprocessor) emit(line string, from ShaderLine) {
+	preprocessor.out.WriteString(line)
+	preprocessor.out.WriteByte('\n')
+	preprocessor.lines = append(preprocessor.lines, from)
+}
+
+var SHADER_IDENTIFIER = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+func (preprocessor *shaderPreprocessor) substitute(line string) string {
+	return SHADER_IDENTIFIER.ReplaceAllStringFunc(line, func(word string) string {
+		if value, ok := preprocessor.defines[word]; ok && value != "" {
+			return value
+		}
+
+		return word
+	})
+}
+
+func (line ShaderLine) String() string {
+	return fmt.Sprintf("%s:%d", line.file, line.line)
+}
+
+// WebGPU reports errors at lines of the preprocessed source, like "wgsl:12:5", which are pointed back at the original file
+
+var SHADER_ERROR_LOCATION = regexp.MustCompile(`wgsl:(\d+):(\d+)`)
+
+func (source *ShaderSource) MapError(err error) error {
+	message := SHADER_ERROR_LOCATION.ReplaceAllStringFunc(err.Error(), func(location string) string {
+		match := SHADER_ERROR_LOCATION.FindStringSubmatch(location)
+		line, _ := strconv.Atoi(match[1])
+
+		if line < 1 || line > len(source.lines) {
+			return location
+		}
+
+		return fmt.Sprintf("%s:%s", source.lines[line-1], match[2])
+	})
+
+	return fmt.Errorf("%s", message)
+}
+
+// permutation of a shader, compiled into a module
+
+type Shader struct {
+	name    string
+	defines ShaderDefines
+	source  *ShaderSource
+	module  *wgpu.ShaderModule
+
+	reflection *ShaderReflection
+}
+
+func NewShader(state *State, name string, defines ShaderDefines) (*Shader, error) {
+	shader := &Shader{
+		name:    name,
+		defines: defines,
+	}
+
+	var err error
+
+	if shader.source, err = PreprocessShader(name, defines); err != nil {
+		return nil, err
+	}
+
+	if shader.reflection, err = ReflectShader(shader.source.code); err != nil {
+		return nil, shader.source.MapError(err)
+	}
+
+	if shader.module, err = state.device.CreateShaderModule(&wgpu.ShaderModuleDescriptor{
+		Label: fmt.Sprintf("Shader module (%s %s)", name, defines.Key()),
+		WGSLDescriptor: &wgpu.ShaderModuleWGSLDescriptor{
+			Code: shader.source.code,
+		},
+	}); err != nil {
+		return nil, shader.source.MapError(err)
+	}
+
+	return shader, nil
+}
+
+func (shader *Shader) Release() {
+	shader.module.Release()
+}
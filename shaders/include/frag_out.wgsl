@@ -0,0 +1,5 @@
+// what fragment shaders drawing into a single colour attachment return
+
+struct FragOut {
+	@location(0) colour: vec4f,
+};
@@ -0,0 +1,14 @@
+// per-instance transform (in the model's space) and tint
+// the locations must match INSTANCE_VBO_LAYOUT in instances.go
+
+struct Instance {
+	@location(2) transform0: vec4f,
+	@location(3) transform1: vec4f,
+	@location(4) transform2: vec4f,
+	@location(5) transform3: vec4f,
+	@location(6) tint: vec4f,
+};
+
+fn instance_transform(instance: Instance) -> mat4x4<f32> {
+	return mat4x4<f32>(instance.transform0, instance.transform1, instance.transform2, instance.transform3);
+}
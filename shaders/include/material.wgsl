@@ -0,0 +1,36 @@
+// material's parameters, sampler and textures, in group 0
+// the layout must match MATERIAL_PARAMS and the bindings must match MATERIAL_SLOTS in material.go
+// texture slots are only declared if the material fills them, which is what defines their names
+
+struct Material {
+	colour: vec4f,
+	emissive: vec3f,
+	emissive_strength: f32,
+	specular: f32,
+	shininess: f32,
+};
+
+@group(0) @binding(0)
+var<uniform> material: Material;
+@group(0) @binding(1)
+var material_sampler: sampler;
+
+#ifdef ALBEDO_MAP
+@group(0) @binding(2)
+var albedo_map: texture_2d<f32>;
+#endif
+
+#ifdef LIGHTMAP
+@group(0) @binding(3)
+var lightmap: texture_2d<f32>;
+#endif
+
+#ifdef NORMAL_MAP
+@group(0) @binding(4)
+var normal_map: texture_2d<f32>;
+#endif
+
+#ifdef EMISSIVE_MAP
+@group(0) @binding(5)
+var emissive_map: texture_2d<f32>;
+#endif
@@ -0,0 +1,6 @@
+// texture and its sampler, in group 0
+
+@group(0) @binding(0)
+var t: texture_2d<f32>;
+@group(0) @binding(1)
+var s: sampler;
@@ -0,0 +1,6 @@
+// view/projection and model matrix, at dynamic offsets in the per-frame uniform ring
+
+@group(1) @binding(0)
+var<uniform> camera: mat4x4<f32>;
+@group(1) @binding(1)
+var<uniform> model: mat4x4<f32>;
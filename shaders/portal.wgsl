@@ -0,0 +1,39 @@
+#include "include/texture.wgsl"
+#include "include/frag_out.wgsl"
+
+struct Portal {
+	mvp: mat4x4<f32>,
+	screen: vec2f,
+}
+
+struct VertOut {
+	@builtin(position) pos: vec4f,
+};
+
+@group(0) @binding(2)
+var<uniform> portal: Portal;
+
+@vertex
+fn vert_main(
+	@location(0) pos: vec3f,
+	@location(1) uv: vec2f,
+) -> VertOut {
+	var out: VertOut;
+
+	out.pos = portal.mvp * vec4(pos, 1.);
+
+	return out;
+}
+
+// what's seen through the portal was rendered from the same point of view, so sample it in screen space
+
+@fragment
+fn frag_main(vert: VertOut) -> FragOut {
+	var out: FragOut;
+
+	var tex_colour = textureSample(t, s, vert.pos.xy / portal.screen);
+
+	out.colour = vec4f(tex_colour.rgb, 1.0);
+
+	return out;
+}
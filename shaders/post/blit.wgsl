@@ -0,0 +1,12 @@
+// copies the final HDR image to the swapchain
+
+#include "post/common.wgsl"
+
+@fragment
+fn frag_main(vert: VertOut) -> FragOut {
+	var out: FragOut;
+
+	out.colour = vec4f(clamp(textureSample(t, s, vert.uv).rgb, vec3f(0.), vec3f(1.)), 1.);
+
+	return out;
+}
@@ -0,0 +1,35 @@
+// params: threshold, intensity, radius (in pixels)
+// single pass, so the glow is gathered from a couple of rings of samples around each pixel rather than properly blurred
+
+#include "post/common.wgsl"
+
+const BLOOM_SAMPLES = 12;
+
+fn bright(uv: vec2f) -> vec3f {
+	let colour = textureSampleLevel(t, s, uv, 0.).rgb;
+	return max(colour - param(0u), vec3f(0.));
+}
+
+@fragment
+fn frag_main(vert: VertOut) -> FragOut {
+	var out: FragOut;
+
+	let colour = textureSample(t, s, vert.uv).rgb;
+	let radius = param(2u) / post.screen;
+
+	var glow = bright(vert.uv);
+	var total = 1.;
+
+	for (var i = 0; i < BLOOM_SAMPLES; i++) {
+		let angle = f32(i) / f32(BLOOM_SAMPLES) * 6.2831853;
+		let dir = vec2f(cos(angle), sin(angle));
+
+		glow += bright(vert.uv + dir * radius) * .6;
+		glow += bright(vert.uv + dir * radius * 2.) * .3;
+		total += .9;
+	}
+
+	out.colour = vec4f(colour + glow / total * param(1u), 1.);
+
+	return out;
+}
@@ -0,0 +1,37 @@
+// shared by all post-processing passes, which include this
+
+#include "include/texture.wgsl"
+#include "include/frag_out.wgsl"
+
+struct Post {
+	screen: vec2f,
+	time: f32,
+	fade: f32, // how far into a world transition we are
+	params: array<vec4f, 2>,
+}
+
+struct VertOut {
+	@builtin(position) pos: vec4f,
+	@location(0) uv: vec2f,
+};
+
+@group(0) @binding(2)
+var<uniform> post: Post;
+
+// a single triangle covering the whole screen
+
+@vertex
+fn vert_main(@builtin(vertex_index) index: u32) -> VertOut {
+	var out: VertOut;
+
+	let uv = vec2f(f32((index << 1u) & 2u), f32(index & 2u));
+
+	out.pos = vec4f(uv * 2. - 1., 0., 1.);
+	out.uv = vec2f(uv.x, 1. - uv.y);
+
+	return out;
+}
+
+fn param(i: u32) -> f32 {
+	return post.params[i / 4u][i % 4u];
+}
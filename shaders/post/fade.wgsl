@@ -0,0 +1,16 @@
+// params: amount
+// also fades out and back in during world transitions
+
+#include "post/common.wgsl"
+
+@fragment
+fn frag_main(vert: VertOut) -> FragOut {
+	var out: FragOut;
+
+	let colour = textureSample(t, s, vert.uv).rgb;
+	let amount = clamp(max(param(0u), post.fade), 0., 1.);
+
+	out.colour = vec4f(colour * (1. - amount), 1.);
+
+	return out;
+}
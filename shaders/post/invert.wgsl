@@ -0,0 +1,13 @@
+// params: amount
+
+#include "post/common.wgsl"
+
+@fragment
+fn frag_main(vert: VertOut) -> FragOut {
+	var out: FragOut;
+
+	let colour = clamp(textureSample(t, s, vert.uv).rgb, vec3f(0.), vec3f(1.));
+	out.colour = vec4f(mix(colour, 1. - colour, param(0u)), 1.);
+
+	return out;
+}
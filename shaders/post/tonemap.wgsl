@@ -0,0 +1,16 @@
+// params: exposure
+// ACES filmic curve, as fitted by Krzysztof Narkowicz
+
+#include "post/common.wgsl"
+
+@fragment
+fn frag_main(vert: VertOut) -> FragOut {
+	var out: FragOut;
+
+	let colour = textureSample(t, s, vert.uv).rgb * param(0u);
+	let mapped = (colour * (2.51 * colour + .03)) / (colour * (2.43 * colour + .59) + .14);
+
+	out.colour = vec4f(clamp(mapped, vec3f(0.), vec3f(1.)), 1.);
+
+	return out;
+}
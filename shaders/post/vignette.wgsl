@@ -0,0 +1,16 @@
+// params: strength, radius (from the centre to the corners, where darkening starts)
+
+#include "post/common.wgsl"
+
+@fragment
+fn frag_main(vert: VertOut) -> FragOut {
+	var out: FragOut;
+
+	let colour = textureSample(t, s, vert.uv).rgb;
+	let dist = length(vert.uv - .5) / length(vec2f(.5));
+	let darken = smoothstep(param(1u), 1., dist) * param(0u);
+
+	out.colour = vec4f(colour * (1. - darken), 1.);
+
+	return out;
+}
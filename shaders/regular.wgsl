@@ -1,42 +1,232 @@
+// permutations, other than those of the material's texture slots (see include/material.wgsl):
+// FOG - colours fade into FOG_COLOUR with distance from the eye, at a rate of FOG_DENSITY per Aylin
+
+#include "include/uniforms.wgsl"
+#include "include/instance.wgsl"
+#include "include/material.wgsl"
+#include "include/frag_out.wgsl"
+
+#ifndef FOG_COLOUR
+#define FOG_COLOUR vec3(.6, .65, .7)
+#endif
+
+#ifndef FOG_DENSITY
+#define FOG_DENSITY .04
+#endif
+
 struct VertOut {
 	@builtin(position) pos: vec4f,
 	@location(0) colour: vec3f,
 	@location(1) uv: vec2f,
+	@location(2) world_pos: vec3f,
+	@location(3) normal: vec3f,
 };
 
-@group(0) @binding(2)
-var<uniform> mvp: mat4x4<f32>;
-
 @vertex
 fn vert_main(
 	@location(0) pos: vec3f,
 	@location(1) uv: vec2f,
+	@location(7) normal: vec3f,
+	instance: Instance,
 ) -> VertOut {
 	var out: VertOut;
 
-	out.pos = mvp * vec4(pos, 1.);
-	out.colour = vec3(1., 1., 1.);
+	let transform = model * instance_transform(instance);
+	let world_pos = transform * vec4(pos, 1.);
+
+	out.pos = camera * world_pos;
+	out.colour = instance.tint.rgb;
 	out.uv = uv;
+	out.world_pos = world_pos.xyz;
+
+	// transforms are only ever scaled uniformly, so there's no need for the inverse transpose
+
+	out.normal = (transform * vec4(normal, 0.)).xyz;
 
 	return out;
 }
 
-struct FragOut {
-	@location(0) colour: vec4f,
+// dynamic lights, in world space
+// the layout of these must match the structs in lights.go
+
+const LIGHT_DIRECTIONAL: u32 = 0u;
+const LIGHT_POINT: u32 = 1u;
+const LIGHT_SPOT: u32 = 2u;
+
+const MAX_LIGHTS: u32 = 16u;
+const SHADOW_LAYERS: u32 = 8u;
+const SHADOW_CASCADES: i32 = 3;
+const SHADOW_BIAS: f32 = .002;
+
+struct Light {
+	pos: vec3f,
+	kind: u32,
+	dir: vec3f,
+	radius: f32,
+	colour: vec3f,
+	intensity: f32,
+	cone: vec2f,
+	shadow: i32, // first shadow map layer, or -1
 };
 
-@group(0) @binding(0)
-var t: texture_2d<f32>;
-@group(0) @binding(1)
-var s: sampler;
+struct Lights {
+	eye: vec3f,
+	count: u32,
+	lights: array<Light, MAX_LIGHTS>,
+	shadow_vps: array<mat4x4<f32>, SHADOW_LAYERS>,
+	shadow_texel: f32,
+	shadow_filter: i32,
+};
+
+@group(2) @binding(0)
+var<uniform> lights: Lights;
+@group(2) @binding(1)
+var shadow_map: texture_depth_2d_array;
+@group(2) @binding(2)
+var shadow_sampler: sampler_comparison;
+
+// how much of a light reaches a point, from 0 in full shadow to 1 fully lit
+// directional lights take the first of their cascades the point is in, and points outside every cascade aren't shadowed
+
+fn shadow(light: Light, world_pos: vec3f) -> f32 {
+	if light.shadow < 0 {
+		return 1.;
+	}
+
+	var layers = 1;
+
+	if light.kind == LIGHT_DIRECTIONAL {
+		layers = SHADOW_CASCADES;
+	}
+
+	for (var i = 0; i < layers; i++) {
+		let layer = light.shadow + i;
+		let clip = lights.shadow_vps[layer] * vec4(world_pos, 1.);
+		let ndc = clip.xyz / clip.w;
+		let uv = ndc.xy * vec2(.5, -.5) + .5;
+
+		if clip.w <= 0. || any(uv < vec2(0.)) || any(uv > vec2(1.)) || ndc.z < 0. || ndc.z > 1. {
+			continue;
+		}
+
+		// percentage-closer filtering over a square of texels
+
+		let size = max(lights.shadow_filter, 0);
+		var lit = 0.;
+		var taps = 0.;
+
+		for (var x = -size; x <= size; x++) {
+			for (var y = -size; y <= size; y++) {
+				let offset = vec2(f32(x), f32(y)) * lights.shadow_texel;
+				lit += textureSampleCompareLevel(shadow_map, shadow_sampler, uv + offset, layer, ndc.z - SHADOW_BIAS);
+				taps += 1.;
+			}
+		}
+
+		return lit / taps;
+	}
+
+	return 1.;
+}
+
+#ifdef NORMAL_MAP
+// vertices don't have tangents, so the tangent frame is worked out from how the position and UVs change across the screen
+
+fn perturb_normal(normal: vec3f, world_pos: vec3f, uv: vec2f) -> vec3f {
+	let dp_dx = dpdx(world_pos);
+	let dp_dy = dpdy(world_pos);
+	let duv_dx = dpdx(uv);
+	let duv_dy = dpdy(uv);
+
+	let dp_dy_perp = cross(dp_dy, normal);
+	let dp_dx_perp = cross(normal, dp_dx);
+
+	let tangent = dp_dy_perp * duv_dx.x + dp_dx_perp * duv_dy.x;
+	let bitangent = dp_dy_perp * duv_dx.y + dp_dx_perp * duv_dy.y;
+	let scale = inverseSqrt(max(max(dot(tangent, tangent), dot(bitangent, bitangent)), 1e-12));
+
+	let sampled = textureSample(normal_map, material_sampler, uv).xyz * 2. - 1.;
+
+	return normalize(mat3x3<f32>(tangent * scale, bitangent * scale, normal) * sampled);
+}
+#endif
 
 @fragment
 fn frag_main(vert: VertOut) -> FragOut {
 	var out: FragOut;
 
-	var tex_colour = textureSample(t, s, vert.uv.yx);
+	// the UVs of IVX models are the other way around
+
+	let uv = vert.uv.yx;
+	var tex_colour = material.colour;
+
+#ifdef ALBEDO_MAP
+	tex_colour *= textureSample(albedo_map, material_sampler, uv);
+#endif
+
+#ifdef LIGHTMAP
+	tex_colour *= textureSample(lightmap, material_sampler, uv);
+#endif
+
+	var emissive = material.emissive * material.emissive_strength;
+
+#ifdef EMISSIVE_MAP
+	emissive *= textureSample(emissive_map, material_sampler, uv).rgb;
+#endif
+
+	// Blinn-Phong, with surfaces lit from both sides as they aren't culled
+
+	let view = normalize(lights.eye - vert.world_pos);
+	var normal = normalize(vert.normal);
+
+#ifdef NORMAL_MAP
+	normal = perturb_normal(normal, vert.world_pos, uv);
+#endif
+
+	if dot(normal, view) < 0. {
+		normal = -normal;
+	}
+
+	var diffuse = vec3(0.);
+	var specular = vec3(0.);
+
+	for (var i = 0u; i < min(lights.count, MAX_LIGHTS); i++) {
+		let light = lights.lights[i];
+
+		var to_light = -light.dir;
+		var attenuation = 1.;
+
+		if light.kind != LIGHT_DIRECTIONAL {
+			let delta = light.pos - vert.world_pos;
+			let dist = length(delta);
+
+			to_light = delta / max(dist, .0001);
+
+			let falloff = clamp(1. - pow(dist / light.radius, 2.), 0., 1.);
+			attenuation = falloff * falloff;
+		}
+
+		if light.kind == LIGHT_SPOT {
+			attenuation *= smoothstep(light.cone.y, light.cone.x, dot(-to_light, light.dir));
+		}
+
+		let radiance = light.colour * light.intensity * attenuation * shadow(light, vert.world_pos);
+		let half_dir = normalize(to_light + view);
+
+		diffuse += radiance * max(dot(normal, to_light), 0.);
+		specular += radiance * material.specular * pow(max(dot(normal, half_dir), 0.), material.shininess);
+	}
+
+	// the baked lightmap is already in the texture colour, dynamic light adds onto it, and emissive light onto that
+
+	var colour = tex_colour.rgb * vert.colour * (1. + diffuse) + specular + emissive;
+
+#ifdef FOG
+	let fog = 1. - exp(-FOG_DENSITY * distance(lights.eye, vert.world_pos));
+	colour = mix(colour, FOG_COLOUR, fog);
+#endif
 
-	out.colour = vec4f(tex_colour.rgb, 1.0) * vec4(vert.colour, 1.);
+	out.colour = vec4(colour, tex_colour.a);
 
 	return out;
 }
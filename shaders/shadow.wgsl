@@ -0,0 +1,13 @@
+// depth of models as seen from a light, for its shadow map
+// the light's view/projection is the camera
+
+#include "include/uniforms.wgsl"
+#include "include/instance.wgsl"
+
+@vertex
+fn vert_main(
+	@location(0) pos: vec3f,
+	instance: Instance,
+) -> @builtin(position) vec4f {
+	return camera * model * instance_transform(instance) * vec4(pos, 1.);
+}
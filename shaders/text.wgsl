@@ -1,3 +1,6 @@
+#include "include/texture.wgsl"
+#include "include/frag_out.wgsl"
+
 struct Text {
 	x: f32,
 	y: f32,
@@ -47,15 +50,6 @@ fn vert_main(
 	return out;
 }
 
-struct FragOut {
-	@location(0) colour: vec4f,
-};
-
-@group(0) @binding(0)
-var t: texture_2d<f32>;
-@group(0) @binding(1)
-var s: sampler;
-
 @fragment
 fn frag_main(vert: VertOut) -> FragOut {
 	var out: FragOut;
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// shadow maps of the dynamic lights which cast shadows, all layers of the same depth texture
+// directional lights get a cascade of layers, each covering a slice of the player's view further away than the last
+// spot lights get a single layer, and point lights don't cast shadows
+
+const SHADOW_LAYERS = 8
+const SHADOW_CASCADES = 3
+
+// far end of each cascade's slice, in Aylins from the player's eye
+// the last one is the far plane of the player's projection
+
+var SHADOW_CASCADE_SPLITS = [SHADOW_CASCADES]float32{4, 12, PLAYER_FAR}
+
+// how far behind a cascade's slice casters are still drawn into it, as they can cast shadows into the slice from outside it
+
+const SHADOW_CASTER_MARGIN = 20
+const SHADOW_SPOT_NEAR = 0.05
+
+const DEFAULT_SHADOW_RESOLUTION = 1024
+const DEFAULT_SHADOW_FILTER = 1
+
+// shadow projections put depth between 0 and 1 as WebGPU expects, instead of between -1 and 1 like the player's
+
+var SHADOW_DEPTH_REMAP = Mat{Data: [4][4]float32{
+	{1, 0, 0, 0},
+	{0, 1, 0, 0},
+	{0, 0, 0.5, 0},
+	{0, 0, 0.5, 1},
+}}
+
+type ShadowMap struct {
+	state *State
+
+	texture *Texture
+	layers  []*wgpu.TextureView // one per layer, to render into
+
+	resolution uint32
+	filter     int // how many texels around each lookup are also looked up, in each direction
+
+	vps    [SHADOW_LAYERS]Mat
+	warned bool
+}
+
+func NewShadowMap(state *State, resolution uint32, filter int) (*ShadowMap, error) {
+	shadows := &ShadowMap{
+		state:      state,
+		resolution: resolution,
+		filter:     filter,
+	}
+
+	var err error
+
+	if shadows.texture, err = NewDepthTextureArray(state, "Shadow map", resolution, resolution, SHADOW_LAYERS); err != nil {
+		return nil, err
+	}
+
+	for i := uint32(0); i < SHADOW_LAYERS; i++ {
+		layer, err := shadows.texture.texture.CreateView(&wgpu.TextureViewDescriptor{
+			Label:           fmt.Sprintf("Shadow map (layer %d)", i),
+			Format:          DEPTH_FORMAT,
+			Dimension:       wgpu.TextureViewDimension_2D,
+			MipLevelCount:   1,
+			BaseArrayLayer:  i,
+			ArrayLayerCount: 1,
+			Aspect:          wgpu.TextureAspect_All,
+		})
+
+		if err != nil {
+			shadows.Release()
+			return nil, err
+		}
+
+		shadows.layers = append(shadows.layers, layer)
+	}
+
+	return shadows, nil
+}
+
+// render the shadow maps of every light which casts shadows, and assign them their layers
+// lights are expected to be on, and this must happen before anything lit by them is rendered in the frame
+
+func (shadows *ShadowMap) Render(lights []*Light) {
+	frame := shadows.state.frame
+
+	colour, depth := frame.Targets()
+	defer frame.SetTargets(colour, depth)
+
+	layer := 0
+
+	for _, light := range lights {
+		light.shadow_layer = -1
+
+		if !light.shadows || light.casters == nil || light.kind == LIGHT_POINT {
+			continue
+		}
+
+		vps := shadows.viewProjections(light)
+
+		if layer+len(vps) > SHADOW_LAYERS {
+			if !shadows.warned {
+				log.Printf("Not enough shadow map layers for every light, only %d\n", SHADOW_LAYERS)
+				shadows.warned = true
+			}
+
+			continue
+		}
+
+		light.shadow_layer = layer
+
+		for _, vp := range vps {
+			shadows.vps[layer] = *vp
+			frame.SetTargets(nil, shadows.layers[layer])
+			light.casters.RenderDepth(fmt.Sprintf("Shadow map (layer %d)", layer), vp)
+			layer++
+		}
+	}
+}
+
+func (shadows *ShadowMap) viewProjections(light *Light) []*Mat {
+	position, direction := light.WorldSpace()
+
+	up := [3]float32{0, 1, 0}
+
+	if math.Abs(float64(direction[1])) > 0.99 {
+		up = [3]float32{0, 0, 1}
+	}
+
+	if light.kind == LIGHT_SPOT {
+		view := NewMat().LookAt(position, [3]float32{position[0] + direction[0], position[1] + direction[1], position[2] + direction[2]}, up)
+		proj := NewMat().Perspective(2*light.outer, 1, SHADOW_SPOT_NEAR, light.radius)
+
+		return []*Mat{SHADOW_DEPTH_REMAP.Copy().Multiply(proj).Multiply(view)}
+	}
+
+	// cascades are fitted around the player's own view, even when rendering through portals
+	// what's outside of every cascade just isn't shadowed
+	// shadows are rendered before the player's camera is, so its projection is worked out here rather than left over from the last frame
+
+	player := shadows.state.player
+	inv_view := player.View().Inverse()
+	player_proj := player.Projection()
+
+	rotation := NewMat().LookAt([3]float32{0, 0, 0}, direction, up)
+	texels := float32(shadows.resolution)
+
+	vps := make([]*Mat, SHADOW_CASCADES)
+	near := float32(0)
+
+	for i, far := range SHADOW_CASCADE_SPLITS {
+		// corners of the slice, from the projection's field of view
+
+		var corners [8][3]float32
+
+		for j := range corners {
+			d := near
+
+			if j&4 != 0 {
+				d = far
+			}
+
+			x := d / player_proj.Data[0][0]
+			y := d / player_proj.Data[1][1]
+
+			if j&1 != 0 {
+				x = -x
+			}
+
+			if j&2 != 0 {
+				y = -y
+			}
+
+			corners[j] = inv_view.TransformPoint([3]float32{x, y, -d})
+		}
+
+		// a bounding sphere keeps the cascade the same size whichever way the player looks
+
+		var centre [3]float32
+
+		for _, corner := range corners {
+			for k := 0; k < 3; k++ {
+				centre[k] += corner[k] / 8
+			}
+		}
+
+		radius := float32(0)
+
+		for _, corner := range corners {
+			dx, dy, dz := corner[0]-centre[0], corner[1]-centre[1], corner[2]-centre[2]
+			radius = float32(math.Max(float64(radius), math.Sqrt(float64(dx*dx+dy*dy+dz*dz))))
+		}
+
+		// snapping the centre to texels stops shadow edges from shimmering as the player moves
+
+		centre = rotation.TransformPoint(centre)
+		texel := 2 * radius / texels
+
+		centre[0] = float32(math.Floor(float64(centre[0]/texel))) * texel
+		centre[1] = float32(math.Floor(float64(centre[1]/texel))) * texel
+
+		proj := NewMat().Orthographic(centre[0]-radius, centre[0]+radius, centre[1]-radius, centre[1]+radius, -centre[2]-radius-SHADOW_CASTER_MARGIN, -centre[2]+radius)
+		vps[i] = SHADOW_DEPTH_REMAP.Copy().Multiply(proj).Multiply(rotation)
+
+		near = far
+	}
+
+	return vps
+}
+
+func (shadows *ShadowMap) Release() {
+	for _, layer := range shadows.layers {
+		layer.Release()
+	}
+
+	shadows.texture.Release()
+}
@@ -35,6 +35,10 @@ func (sound_system *SoundSystem) PlaySound(name string, state *State) error {
 	streamer := sound.streamer
 	format := sound.format
 
+	if err := streamer.Seek(0); err != nil {
+		return err
+	}
+
 	ctrl := &beep.Ctrl{Streamer: beep.Loop(1, streamer), Paused: false}
 
 	sound_system.streamer = streamer
@@ -47,6 +51,10 @@ func (sound_system *SoundSystem) PlaySound(name string, state *State) error {
 	return nil
 }
 
+func (sound_system *SoundSystem) Stop() {
+	speaker.Clear()
+}
+
 func (sound_system *SoundSystem) Close() error {
 	if sound_system.streamer != nil {
 		if err := sound_system.streamer.Close(); err != nil {
@@ -61,3 +69,7 @@ func DecodeFile(path string) *DecodedSound {
 	streamer, format, _ := mp3.Decode(f)
 	return &DecodedSound{path, streamer, format}
 }
+
+func (sound *DecodedSound) Duration() float32 {
+	return float32(sound.format.SampleRate.D(sound.streamer.Len()).Seconds())
+}
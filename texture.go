@@ -1,11 +1,9 @@
 package main
 
 import (
-	"bytes"
+	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
-	"image/png"
 
 	"github.com/rajveermalviya/go-webgpu/wgpu"
 	"golang.org/x/image/font"
@@ -17,32 +15,98 @@ type Texture struct {
 	texture *wgpu.Texture
 	view    *wgpu.TextureView
 	sampler *wgpu.Sampler
+
+	resources *ResourceCache // which the sampler is shared through, if it is
+}
+
+// how a texture loaded from an image is sampled, and whether it gets a full mip chain
+
+type TextureOptions struct {
+	mipmaps      bool
+	srgb         bool // colour textures are sRGB, data (e.g. normal maps) is linear
+	filter       wgpu.FilterMode
+	address_mode wgpu.AddressMode
+	anisotropy   uint16 // only used if filtering is linear, as WebGPU requires
+}
+
+var DEFAULT_TEXTURE_OPTIONS = TextureOptions{
+	mipmaps:      true,
+	srgb:         true,
+	filter:       wgpu.FilterMode_Linear,
+	address_mode: wgpu.AddressMode_ClampToEdge,
+	anisotropy:   16,
+}
+
+// text is always drawn at the same size, so it doesn't need mips
+
+var TEXT_TEXTURE_OPTIONS = TextureOptions{
+	mipmaps:      false,
+	srgb:         true,
+	filter:       wgpu.FilterMode_Linear,
+	address_mode: wgpu.AddressMode_ClampToEdge,
+	anisotropy:   1,
+}
+
+func NewSampler(state *State, options TextureOptions) (*wgpu.Sampler, error) {
+	mipmap_filter := wgpu.MipmapFilterMode_Nearest
+
+	if options.filter == wgpu.FilterMode_Linear {
+		mipmap_filter = wgpu.MipmapFilterMode_Linear
+	}
+
+	anisotropy := options.anisotropy
+
+	if anisotropy < 1 || options.filter != wgpu.FilterMode_Linear {
+		anisotropy = 1
+	}
+
+	return state.device.CreateSampler(&wgpu.SamplerDescriptor{
+		AddressModeU:   options.address_mode,
+		AddressModeV:   options.address_mode,
+		AddressModeW:   options.address_mode,
+		MagFilter:      options.filter,
+		MinFilter:      options.filter,
+		MipmapFilter:   mipmap_filter,
+		LodMinClamp:    0,
+		LodMaxClamp:    32,
+		MaxAnisotrophy: anisotropy,
+	})
 }
 
 func NewTextureFromBytes(state *State, label string, buf []byte) (*Texture, error) {
-	img, err := png.Decode(bytes.NewReader(buf))
+	return NewTextureFromBytesWithOptions(state, label, buf, DEFAULT_TEXTURE_OPTIONS)
+}
+
+// the format is sniffed from the data, see DecodeTexture
+
+func NewTextureFromBytesWithOptions(state *State, label string, buf []byte, options TextureOptions) (*Texture, error) {
+	data, err := DecodeTexture(buf, options)
 	if err != nil {
 		return nil, err
 	}
 
-	bounds := img.Bounds()
+	return NewTextureFromData(state, label, data, options)
+}
 
-	rgba, ok := img.(*image.RGBA)
-	if !ok {
-		rgba = image.NewRGBA(bounds)
-		draw.Draw(rgba, bounds, img, image.Point{}, draw.Over)
-	}
+const DEPTH_FORMAT = wgpu.TextureFormat_Depth32Float
 
-	width := uint32(bounds.Dx())
-	height := uint32(bounds.Dy())
+func NewDepthTexture(state *State) (*Texture, error) {
+	width, height := state.Size()
+	return NewDepthTextureArray(state, "Depth", uint32(width), uint32(height), 1)
+}
+
+// depth texture with several layers, e.g. for shadow maps
+// its view covers every layer as an array if there's more than one, and its sampler is a comparison sampler
 
+func NewDepthTextureArray(state *State, label string, width, height, layers uint32) (*Texture, error) {
 	size := wgpu.Extent3D{
 		Width:              width,
 		Height:             height,
-		DepthOrArrayLayers: 1,
+		DepthOrArrayLayers: layers,
 	}
 
 	texture := &Texture{}
+	var err error
 
 	if texture.texture, err = state.device.CreateTexture(&wgpu.TextureDescriptor{
 		Label:         label,
@@ -50,32 +114,26 @@ func NewTextureFromBytes(state *State, label string, buf []byte) (*Texture, erro
 		MipLevelCount: 1,
 		SampleCount:   1,
 		Dimension:     wgpu.TextureDimension_2D,
-		Format:        wgpu.TextureFormat_RGBA8UnormSrgb,
-		Usage:         wgpu.TextureUsage_TextureBinding | wgpu.TextureUsage_CopyDst,
+		Format:        DEPTH_FORMAT,
+		Usage:         wgpu.TextureUsage_RenderAttachment | wgpu.TextureUsage_TextureBinding,
 	}); err != nil {
 		return nil, err
 	}
 
-	if err = state.queue.WriteTexture(
-		&wgpu.ImageCopyTexture{
-			Aspect:   wgpu.TextureAspect_All,
-			Texture:  texture.texture,
-			MipLevel: 0,
-			Origin:   wgpu.Origin3D{},
-		},
-		rgba.Pix,
-		&wgpu.TextureDataLayout{
-			Offset:       0,
-			BytesPerRow:  4 * width,
-			RowsPerImage: height,
-		},
-		&size,
-	); err != nil {
-		texture.texture.Release()
-		return nil, err
+	var view_descriptor *wgpu.TextureViewDescriptor
+
+	if layers > 1 {
+		view_descriptor = &wgpu.TextureViewDescriptor{
+			Label:           label,
+			Format:          DEPTH_FORMAT,
+			Dimension:       wgpu.TextureViewDimension_2DArray,
+			MipLevelCount:   1,
+			ArrayLayerCount: layers,
+			Aspect:          wgpu.TextureAspect_All,
+		}
 	}
 
-	if texture.view, err = texture.texture.CreateView(nil); err != nil {
+	if texture.view, err = texture.texture.CreateView(view_descriptor); err != nil {
 		texture.texture.Release()
 		return nil, err
 	}
@@ -84,10 +142,13 @@ func NewTextureFromBytes(state *State, label string, buf []byte) (*Texture, erro
 		AddressModeU:   wgpu.AddressMode_ClampToEdge,
 		AddressModeV:   wgpu.AddressMode_ClampToEdge,
 		AddressModeW:   wgpu.AddressMode_ClampToEdge,
-		MagFilter:      wgpu.FilterMode_Linear,
-		MinFilter:      wgpu.FilterMode_Linear,
-		MipmapFilter:   wgpu.MipmapFilterMode_Linear,
+		MagFilter:      wgpu.FilterMode_Nearest,
+		MinFilter:      wgpu.FilterMode_Nearest,
+		MipmapFilter:   wgpu.MipmapFilterMode_Nearest,
+		Compare:        wgpu.CompareFunction_Less,
 		MaxAnisotrophy: 1,
+		LodMinClamp:    0,
+		LodMaxClamp:    100,
 	}); err != nil {
 		texture.texture.Release()
 		texture.view.Release()
@@ -97,14 +158,18 @@ func NewTextureFromBytes(state *State, label string, buf []byte) (*Texture, erro
 	return texture, nil
 }
 
-const DEPTH_FORMAT = wgpu.TextureFormat_Depth32Float
+// texture which can be rendered to and then sampled from, e.g. what's seen through a portal
 
-func NewDepthTexture(state *State) (*Texture, error) {
-	width, height := state.win.GetSize()
+func NewRenderTexture(state *State, label string, format wgpu.TextureFormat, width, height uint32) (*Texture, error) {
+	return NewRenderTextureWithUsage(state, label, format, width, height, 0)
+}
 
+// same, but with extra usages on top, e.g. to copy what was rendered back from the GPU
+
+func NewRenderTextureWithUsage(state *State, label string, format wgpu.TextureFormat, width, height uint32, usage wgpu.TextureUsage) (*Texture, error) {
 	size := wgpu.Extent3D{
-		Width:              uint32(width),
-		Height:             uint32(height),
+		Width:              width,
+		Height:             height,
 		DepthOrArrayLayers: 1,
 	}
 
@@ -112,13 +177,13 @@ func NewDepthTexture(state *State) (*Texture, error) {
 	var err error
 
 	if texture.texture, err = state.device.CreateTexture(&wgpu.TextureDescriptor{
-		Label:         "Depth",
+		Label:         label,
 		Size:          size,
 		MipLevelCount: 1,
 		SampleCount:   1,
 		Dimension:     wgpu.TextureDimension_2D,
-		Format:        DEPTH_FORMAT,
-		Usage:         wgpu.TextureUsage_RenderAttachment | wgpu.TextureUsage_TextureBinding,
+		Format:        format,
+		Usage:         wgpu.TextureUsage_RenderAttachment | wgpu.TextureUsage_TextureBinding | usage,
 	}); err != nil {
 		return nil, err
 	}
@@ -135,10 +200,7 @@ func NewDepthTexture(state *State) (*Texture, error) {
 		MagFilter:      wgpu.FilterMode_Nearest,
 		MinFilter:      wgpu.FilterMode_Nearest,
 		MipmapFilter:   wgpu.MipmapFilterMode_Nearest,
-		Compare:        wgpu.CompareFunction_Less,
 		MaxAnisotrophy: 1,
-		LodMinClamp:    0,
-		LodMaxClamp:    100,
 	}); err != nil {
 		texture.texture.Release()
 		texture.view.Release()
@@ -150,7 +212,7 @@ func NewDepthTexture(state *State) (*Texture, error) {
 
 func NewTextureFromText(state *State, label, text string) (*Texture, error) {
 	img := textToImage(text)
-	return NewTextureFromImage(state, label, img)
+	return NewTextureFromImageWithOptions(state, label, img, TEXT_TEXTURE_OPTIONS)
 }
 
 func textToImage(text string) image.Image {
@@ -169,55 +231,75 @@ func textToImage(text string) image.Image {
 }
 
 func NewTextureFromImage(state *State, label string, img image.Image) (*Texture, error) {
-	bounds := img.Bounds()
+	return NewTextureFromImageWithOptions(state, label, img, DEFAULT_TEXTURE_OPTIONS)
+}
+
+func NewTextureFromImageWithOptions(state *State, label string, img image.Image, options TextureOptions) (*Texture, error) {
+	return NewTextureFromData(state, label, TextureDataFromImage(img, options), options)
+}
 
-	rgba, ok := img.(*image.RGBA)
+func NewTextureFromData(state *State, label string, data *TextureData, options TextureOptions) (*Texture, error) {
+	info, ok := TEXTURE_FORMATS[data.format]
 	if !ok {
-		rgba = image.NewRGBA(bounds)
-		draw.Draw(rgba, bounds, img, image.Point{}, draw.Over)
+		return nil, fmt.Errorf("texture %q has unsupported format %s", label, data.format)
 	}
 
-	width := uint32(bounds.Dx())
-	height := uint32(bounds.Dy())
+	if info.feature != wgpu.FeatureName_Undefined && !state.device.HasFeature(info.feature) {
+		return nil, fmt.Errorf("texture %q is %s, which needs %s, but the adapter doesn't support it", label, data.format, info.feature)
+	}
 
-	size := wgpu.Extent3D{
-		Width:              width,
-		Height:             height,
-		DepthOrArrayLayers: 1,
+	if err := info.checkSize(data.width, data.height); err != nil {
+		return nil, fmt.Errorf("texture %q: %w", label, err)
 	}
 
 	texture := &Texture{}
-
 	var err error
+
 	if texture.texture, err = state.device.CreateTexture(&wgpu.TextureDescriptor{
-		Label:         label,
-		Size:          size,
-		MipLevelCount: 1,
+		Label: label,
+		Size: wgpu.Extent3D{
+			Width:              data.width,
+			Height:             data.height,
+			DepthOrArrayLayers: 1,
+		},
+		MipLevelCount: uint32(len(data.levels)),
 		SampleCount:   1,
 		Dimension:     wgpu.TextureDimension_2D,
-		Format:        wgpu.TextureFormat_RGBA8UnormSrgb,
+		Format:        data.format,
 		Usage:         wgpu.TextureUsage_TextureBinding | wgpu.TextureUsage_CopyDst,
 	}); err != nil {
 		return nil, err
 	}
 
-	if err = state.queue.WriteTexture(
-		&wgpu.ImageCopyTexture{
-			Aspect:   wgpu.TextureAspect_All,
-			Texture:  texture.texture,
-			MipLevel: 0,
-			Origin:   wgpu.Origin3D{},
-		},
-		rgba.Pix,
-		&wgpu.TextureDataLayout{
-			Offset:       0,
-			BytesPerRow:  4 * width,
-			RowsPerImage: height,
-		},
-		&size,
-	); err != nil {
-		texture.texture.Release()
-		return nil, err
+	for i, level := range data.levels {
+		// copies of compressed textures are whole blocks, even when the level is smaller than one
+
+		blocks_x, blocks_y, _ := info.levelSize(data.width, data.height, i)
+
+		size := wgpu.Extent3D{
+			Width:              blocks_x * info.block_size,
+			Height:             blocks_y * info.block_size,
+			DepthOrArrayLayers: 1,
+		}
+
+		if err = state.queue.WriteTexture(
+			&wgpu.ImageCopyTexture{
+				Aspect:   wgpu.TextureAspect_All,
+				Texture:  texture.texture,
+				MipLevel: uint32(i),
+				Origin:   wgpu.Origin3D{},
+			},
+			level,
+			&wgpu.TextureDataLayout{
+				Offset:       0,
+				BytesPerRow:  blocks_x * info.block_bytes,
+				RowsPerImage: blocks_y,
+			},
+			&size,
+		); err != nil {
+			texture.texture.Release()
+			return nil, err
+		}
 	}
 
 	if texture.view, err = texture.texture.CreateView(nil); err != nil {
@@ -225,17 +307,25 @@ func NewTextureFromImage(state *State, label string, img image.Image) (*Texture,
 		return nil, err
 	}
 
-	if texture.sampler, err = state.device.CreateSampler(nil); err != nil {
+	// textures loaded from images all share the samplers for their options
+
+	if texture.sampler, err = state.resources.Sampler(options); err != nil {
 		texture.texture.Release()
 		texture.view.Release()
 		return nil, err
 	}
 
+	texture.resources = state.resources
 	return texture, nil
 }
 
 func (texture *Texture) Release() {
 	texture.texture.Release()
 	texture.view.Release()
-	texture.sampler.Release()
+
+	if texture.resources != nil {
+		texture.resources.Release(texture.sampler)
+	} else {
+		texture.sampler.Release()
+	}
 }
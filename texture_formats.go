@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// texture data as it's uploaded, in whichever format it's stored as
+// levels are the mip chain starting with the full-size image, each one packed row by row, or row of blocks by row of blocks
+
+type TextureData struct {
+	format wgpu.TextureFormat
+	width  uint32
+	height uint32
+	levels [][]byte
+}
+
+// uncompressed formats are made of 1x1 blocks, i.e. texels
+
+type TextureFormatInfo struct {
+	block_size  uint32 // width and height of a block, in texels
+	block_bytes uint32
+	feature     wgpu.FeatureName   // which the device needs to use the format, if any
+	srgb        wgpu.TextureFormat // sRGB counterpart of the format, if it has one
+}
+
+var TEXTURE_FORMATS = map[wgpu.TextureFormat]TextureFormatInfo{
+	wgpu.TextureFormat_RGBA8Unorm:     {1, 4, wgpu.FeatureName_Undefined, wgpu.TextureFormat_RGBA8UnormSrgb},
+	wgpu.TextureFormat_RGBA8UnormSrgb: {1, 4, wgpu.FeatureName_Undefined, wgpu.TextureFormat_Undefined},
+	wgpu.TextureFormat_RGBA16Float:    {1, 8, wgpu.FeatureName_Undefined, wgpu.TextureFormat_Undefined},
+
+	wgpu.TextureFormat_BC1RGBAUnorm:     {4, 8, wgpu.FeatureName_TextureCompressionBC, wgpu.TextureFormat_BC1RGBAUnormSrgb},
+	wgpu.TextureFormat_BC1RGBAUnormSrgb: {4, 8, wgpu.FeatureName_TextureCompressionBC, wgpu.TextureFormat_Undefined},
+	wgpu.TextureFormat_BC2RGBAUnorm:     {4, 16, wgpu.FeatureName_TextureCompressionBC, wgpu.TextureFormat_BC2RGBAUnormSrgb},
+	wgpu.TextureFormat_BC2RGBAUnormSrgb: {4, 16, wgpu.FeatureName_TextureCompressionBC, wgpu.TextureFormat_Undefined},
+	wgpu.TextureFormat_BC3RGBAUnorm:     {4, 16, wgpu.FeatureName_TextureCompressionBC, wgpu.TextureFormat_BC3RGBAUnormSrgb},
+	wgpu.TextureFormat_BC3RGBAUnormSrgb: {4, 16, wgpu.FeatureName_TextureCompressionBC, wgpu.TextureFormat_Undefined},
+	wgpu.TextureFormat_BC4RUnorm:        {4, 8, wgpu.FeatureName_TextureCompressionBC, wgpu.TextureFormat_Undefined},
+	wgpu.TextureFormat_BC5RGUnorm:       {4, 16, wgpu.FeatureName_TextureCompressionBC, wgpu.TextureFormat_Undefined},
+	wgpu.TextureFormat_BC6HRGBUfloat:    {4, 16, wgpu.FeatureName_TextureCompressionBC, wgpu.TextureFormat_Undefined},
+	wgpu.TextureFormat_BC7RGBAUnorm:     {4, 16, wgpu.FeatureName_TextureCompressionBC, wgpu.TextureFormat_BC7RGBAUnormSrgb},
+	wgpu.TextureFormat_BC7RGBAUnormSrgb: {4, 16, wgpu.FeatureName_TextureCompressionBC, wgpu.TextureFormat_Undefined},
+
+	wgpu.TextureFormat_ETC2RGB8Unorm:       {4, 8, wgpu.FeatureName_TextureCompressionETC2, wgpu.TextureFormat_ETC2RGB8UnormSrgb},
+	wgpu.TextureFormat_ETC2RGB8UnormSrgb:   {4, 8, wgpu.FeatureName_TextureCompressionETC2, wgpu.TextureFormat_Undefined},
+	wgpu.TextureFormat_ETC2RGB8A1Unorm:     {4, 8, wgpu.FeatureName_TextureCompressionETC2, wgpu.TextureFormat_ETC2RGB8A1UnormSrgb},
+	wgpu.TextureFormat_ETC2RGB8A1UnormSrgb: {4, 8, wgpu.FeatureName_TextureCompressionETC2, wgpu.TextureFormat_Undefined},
+	wgpu.TextureFormat_ETC2RGBA8Unorm:      {4, 16, wgpu.FeatureName_TextureCompressionETC2, wgpu.TextureFormat_ETC2RGBA8UnormSrgb},
+	wgpu.TextureFormat_ETC2RGBA8UnormSrgb:  {4, 16, wgpu.FeatureName_TextureCompressionETC2, wgpu.TextureFormat_Undefined},
+}
+
+// features needed for compressed textures, which are requested if the adapter has them
+
+var TEXTURE_COMPRESSION_FEATURES = []wgpu.FeatureName{
+	wgpu.FeatureName_TextureCompressionBC,
+	wgpu.FeatureName_TextureCompressionETC2,
+}
+
+// whether a texture is sRGB or linear depends on what it's used for rather than how it's stored
+// colour textures are sRGB, but e.g. normal maps and other data are linear
+
+func (data *TextureData) SetSrgb(srgb bool) {
+	for linear, info := range TEXTURE_FORMATS {
+		if info.srgb == wgpu.TextureFormat_Undefined {
+			continue
+		}
+
+		if srgb && data.format == linear {
+			data.format = info.srgb
+			return
+		}
+
+		if !srgb && data.format == info.srgb {
+			data.format = linear
+			return
+		}
+	}
+}
+
+// size of a mip level in blocks, and how many bytes it takes
+
+func (info TextureFormatInfo) levelSize(width, height uint32, level int) (uint32, uint32, uint32) {
+	width >>= level
+	height >>= level
+
+	if width < 1 {
+		width = 1
+	}
+
+	if height < 1 {
+		height = 1
+	}
+
+	blocks_x := (width + info.block_size - 1) / info.block_size
+	blocks_y := (height + info.block_size - 1) / info.block_size
+
+	return blocks_x, blocks_y, blocks_x * blocks_y * info.block_bytes
+}
+
+// compressed textures must be a whole number of blocks, even if their smaller mip levels aren't
+
+func (info TextureFormatInfo) checkSize(width, height uint32) error {
+	if width%info.block_size != 0 || height%info.block_size != 0 {
+		return fmt.Errorf("%dx%d isn't a whole number of %dx%d blocks", width, height, info.block_size, info.block_size)
+	}
+
+	return nil
+}
+
+// sniff which format a file is in from its first few bytes, and decode it
+
+var KTX2_IDENTIFIER = []byte{0xAB, 'K', 'T', 'X', ' ', '2', '0', 0xBB, '\r', '\n', 0x1A, '\n'}
+
+func DecodeTexture(buf []byte, options TextureOptions) (*TextureData, error) {
+	var data *TextureData
+	var err error
+
+	switch {
+	case bytes.HasPrefix(buf, KTX2_IDENTIFIER):
+		data, err = DecodeKtx2(buf)
+	case bytes.HasPrefix(buf, []byte("DDS ")):
+		data, err = DecodeDds(buf)
+	case bytes.HasPrefix(buf, []byte("#?RADIANCE")) || bytes.HasPrefix(buf, []byte("#?RGBE")):
+		data, err = DecodeRadiance(buf)
+	default:
+		img, _, err := image.Decode(bytes.NewReader(buf))
+		if err != nil {
+			return nil, err
+		}
+
+		return TextureDataFromImage(img, options), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	data.SetSrgb(options.srgb)
+	return data, nil
+}
+
+func TextureDataFromImage(img image.Image, options TextureOptions) *TextureData {
+	bounds := img.Bounds()
+
+	// levels are uploaded straight from the pixels, so they must be packed and start at the origin
+
+	rgba, ok := img.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) || rgba.Stride != 4*bounds.Dx() {
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	}
+
+	levels := []*image.RGBA{rgba}
+
+	if options.mipmaps {
+		levels = GenerateMips(rgba, options.srgb)
+	}
+
+	data := &TextureData{
+		format: wgpu.TextureFormat_RGBA8Unorm,
+		width:  uint32(bounds.Dx()),
+		height: uint32(bounds.Dy()),
+	}
+
+	for _, level := range levels {
+		data.levels = append(data.levels, level.Pix)
+	}
+
+	data.SetSrgb(options.srgb)
+	return data
+}
+
+// slice the mip levels out of a file, one after the other from offset
+
+func sliceLevels(buf []byte, offset uint64, format wgpu.TextureFormat, width, height, level_count uint32) ([][]byte, error) {
+	info := TEXTURE_FORMATS[format]
+	var levels [][]byte
+
+	for i := 0; i < int(level_count); i++ {
+		_, _, size := info.levelSize(width, height, i)
+
+		if offset+uint64(size) > uint64(len(buf)) {
+			return nil, fmt.Errorf("mip level %d is past the end of the file", i)
+		}
+
+		levels = append(levels, buf[offset:offset+uint64(size)])
+		offset += uint64(size)
+	}
+
+	return levels, nil
+}
+
+// KTX2, only for single 2D images without supercompression (so no Basis Universal either)
+
+var KTX2_FORMATS = map[uint32]wgpu.TextureFormat{
+	37:  wgpu.TextureFormat_RGBA8Unorm,
+	43:  wgpu.TextureFormat_RGBA8UnormSrgb,
+	97:  wgpu.TextureFormat_RGBA16Float,
+	131: wgpu.TextureFormat_BC1RGBAUnorm, // BC1 without alpha is the same as BC1 with opaque texels
+	132: wgpu.TextureFormat_BC1RGBAUnormSrgb,
+	133: wgpu.TextureFormat_BC1RGBAUnorm,
+	134: wgpu.TextureFormat_BC1RGBAUnormSrgb,
+	135: wgpu.TextureFormat_BC2RGBAUnorm,
+	136: wgpu.TextureFormat_BC2RGBAUnormSrgb,
+	137: wgpu.TextureFormat_BC3RGBAUnorm,
+	138: wgpu.TextureFormat_BC3RGBAUnormSrgb,
+	139: wgpu.TextureFormat_BC4RUnorm,
+	141: wgpu.TextureFormat_BC5RGUnorm,
+	143: wgpu.TextureFormat_BC6HRGBUfloat,
+	145: wgpu.TextureFormat_BC7RGBAUnorm,
+	146: wgpu.TextureFormat_BC7RGBAUnormSrgb,
+	147: wgpu.TextureFormat_ETC2RGB8Unorm,
+	148: wgpu.TextureFormat_ETC2RGB8UnormSrgb,
+	149: wgpu.TextureFormat_ETC2RGB8A1Unorm,
+	150: wgpu.TextureFormat_ETC2RGB8A1UnormSrgb,
+	151: wgpu.TextureFormat_ETC2RGBA8Unorm,
+	152: wgpu.TextureFormat_ETC2RGBA8UnormSrgb,
+}
+
+const KTX2_HEADER_SIZE = 80
+const KTX2_LEVEL_SIZE = 24
+
+func DecodeKtx2(buf []byte) (*TextureData, error) {
+	if len(buf) < KTX2_HEADER_SIZE {
+		return nil, errors.New("KTX2 file is too short")
+	}
+
+	le := binary.LittleEndian
+
+	vk_format := le.Uint32(buf[12:])
+	width := le.Uint32(buf[20:])
+	height := le.Uint32(buf[24:])
+	depth := le.Uint32(buf[28:])
+	layer_count := le.Uint32(buf[32:])
+	face_count := le.Uint32(buf[36:])
+	level_count := le.Uint32(buf[40:])
+	supercompression := le.Uint32(buf[44:])
+
+	if depth > 1 || layer_count > 1 || face_count != 1 {
+		return nil, errors.New("only 2D KTX2 textures are supported, not 3D, arrays or cubemaps")
+	}
+
+	if supercompression != 0 {
+		return nil, fmt.Errorf("KTX2 supercompression scheme %d isn't supported", supercompression)
+	}
+
+	format, ok := KTX2_FORMATS[vk_format]
+	if !ok {
+		return nil, fmt.Errorf("KTX2 format %d isn't supported", vk_format)
+	}
+
+	// a level count of 0 asks for mips to be generated, which can't be done for compressed formats
+
+	if level_count == 0 {
+		level_count = 1
+	}
+
+	if len(buf) < KTX2_HEADER_SIZE+int(level_count)*KTX2_LEVEL_SIZE {
+		return nil, errors.New("KTX2 level index is past the end of the file")
+	}
+
+	info := TEXTURE_FORMATS[format]
+
+	if err := info.checkSize(width, height); err != nil {
+		return nil, fmt.Errorf("KTX2 texture is %w", err)
+	}
+
+	data := &TextureData{format: format, width: width, height: height}
+
+	for i := 0; i < int(level_count); i++ {
+		index := buf[KTX2_HEADER_SIZE+i*KTX2_LEVEL_SIZE:]
+		offset := le.Uint64(index[0:])
+		length := le.Uint64(index[8:])
+
+		if _, _, size := info.levelSize(width, height, i); uint64(size) != length || offset+length > uint64(len(buf)) {
+			return nil, fmt.Errorf("KTX2 mip level %d is the wrong size or past the end of the file", i)
+		}
+
+		data.levels = append(data.levels, buf[offset:offset+length])
+	}
+
+	return data, nil
+}
+
+// DDS, with either a FourCC code or a DX10 header, or plain RGBA
+
+var DDS_FOURCC_FORMATS = map[string]wgpu.TextureFormat{
+	"DXT1": wgpu.TextureFormat_BC1RGBAUnorm,
+	"DXT3": wgpu.TextureFormat_BC2RGBAUnorm,
+	"DXT5": wgpu.TextureFormat_BC3RGBAUnorm,
+	"ATI1": wgpu.TextureFormat_BC4RUnorm,
+	"BC4U": wgpu.TextureFormat_BC4RUnorm,
+	"ATI2": wgpu.TextureFormat_BC5RGUnorm,
+	"BC5U": wgpu.TextureFormat_BC5RGUnorm,
+}
+
+var DDS_DXGI_FORMATS = map[uint32]wgpu.TextureFormat{
+	10: wgpu.TextureFormat_RGBA16Float,
+	28: wgpu.TextureFormat_RGBA8Unorm,
+	29: wgpu.TextureFormat_RGBA8UnormSrgb,
+	71: wgpu.TextureFormat_BC1RGBAUnorm,
+	72: wgpu.TextureFormat_BC1RGBAUnormSrgb,
+	74: wgpu.TextureFormat_BC2RGBAUnorm,
+	75: wgpu.TextureFormat_BC2RGBAUnormSrgb,
+	77: wgpu.TextureFormat_BC3RGBAUnorm,
+	78: wgpu.TextureFormat_BC3RGBAUnormSrgb,
+	80: wgpu.TextureFormat_BC4RUnorm,
+	83: wgpu.TextureFormat_BC5RGUnorm,
+	95: wgpu.TextureFormat_BC6HRGBUfloat,
+	98: wgpu.TextureFormat_BC7RGBAUnorm,
+	99: wgpu.TextureFormat_BC7RGBAUnormSrgb,
+}
+
+const DDS_HEADER_SIZE = 128 // including the magic
+const DDS_DX10_HEADER_SIZE = 20
+
+const DDSD_MIPMAPCOUNT = 0x20000
+const DDPF_FOURCC = 0x4
+
+func DecodeDds(buf []byte) (*TextureData, error) {
+	if len(buf) < DDS_HEADER_SIZE {
+		return nil, errors.New("DDS file is too short")
+	}
+
+	le := binary.LittleEndian
+
+	flags := le.Uint32(buf[8:])
+	height := le.Uint32(buf[12:])
+	width := le.Uint32(buf[16:])
+	level_count := uint32(1)
+
+	if flags&DDSD_MIPMAPCOUNT != 0 && le.Uint32(buf[28:]) > 0 {
+		level_count = le.Uint32(buf[28:])
+	}
+
+	pf_flags := le.Uint32(buf[80:])
+	fourcc := string(buf[84:88])
+	offset := uint64(DDS_HEADER_SIZE)
+
+	var format wgpu.TextureFormat
+	ok := false
+
+	switch {
+	case pf_flags&DDPF_FOURCC != 0 && fourcc == "DX10":
+		if len(buf) < DDS_HEADER_SIZE+DDS_DX10_HEADER_SIZE {
+			return nil, errors.New("DDS DX10 header is past the end of the file")
+		}
+
+		dxgi_format := le.Uint32(buf[DDS_HEADER_SIZE:])
+		offset += DDS_DX10_HEADER_SIZE
+
+		if format, ok = DDS_DXGI_FORMATS[dxgi_format]; !ok {
+			return nil, fmt.Errorf("DDS DXGI format %d isn't supported", dxgi_format)
+		}
+
+	case pf_flags&DDPF_FOURCC != 0:
+		if format, ok = DDS_FOURCC_FORMATS[fourcc]; !ok {
+			return nil, fmt.Errorf("DDS FourCC %q isn't supported", strings.TrimRight(fourcc, "\x00"))
+		}
+
+	default:
+		// only the one uncompressed layout WebGPU has as is
+
+		bit_count := le.Uint32(buf[88:])
+		masks := [4]uint32{le.Uint32(buf[92:]), le.Uint32(buf[96:]), le.Uint32(buf[100:]), le.Uint32(buf[104:])}
+
+		if bit_count != 32 || masks != [4]uint32{0xFF, 0xFF00, 0xFF0000, 0xFF000000} {
+			return nil, errors.New("only RGBA8 uncompressed DDS textures are supported")
+		}
+
+		format = wgpu.TextureFormat_RGBA8Unorm
+	}
+
+	if err := TEXTURE_FORMATS[format].checkSize(width, height); err != nil {
+		return nil, fmt.Errorf("DDS texture is %w", err)
+	}
+
+	levels, err := sliceLevels(buf, offset, format, width, height, level_count)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TextureData{format: format, width: width, height: height, levels: levels}, nil
+}
+
+// Radiance RGBE (.hdr) images, e.g. for skyboxes, decoded to half floats
+// they don't get mips, as skyboxes are always sampled at about the same resolution
+
+func DecodeRadiance(buf []byte) (*TextureData, error) {
+	reader := bytes.NewReader(buf)
+
+	readLine := func() (string, error) {
+		var line []byte
+
+		for {
+			c, err := reader.ReadByte()
+			if err != nil {
+				return "", errors.New("Radiance header is past the end of the file")
+			}
+
+			if c == '\n' {
+				return string(line), nil
+			}
+
+			line = append(line, c)
+		}
+	}
+
+	// header lines, up to an empty line
+
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if line == "" {
+			break
+		}
+
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("Radiance format %q isn't supported", strings.TrimPrefix(line, "FORMAT="))
+		}
+	}
+
+	line, err := readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	var width, height int
+
+	if _, err := fmt.Sscanf(line, "-Y %d +X %d", &height, &width); err != nil {
+		return nil, fmt.Errorf("Radiance resolution %q isn't supported, only top to bottom and left to right", line)
+	}
+
+	pixels := make([]uint16, width*height*4)
+	scanline := make([]byte, width*4)
+
+	for y := 0; y < height; y++ {
+		if err := readRadianceScanline(reader, scanline, width); err != nil {
+			return nil, fmt.Errorf("Radiance scanline %d: %w", y, err)
+		}
+
+		for x := 0; x < width; x++ {
+			rgbe := scanline[x*4 : x*4+4]
+			var rgb [3]float32
+
+			if rgbe[3] != 0 {
+				scale := float32(math.Ldexp(1, int(rgbe[3])-(128+8)))
+
+				for k := 0; k < 3; k++ {
+					rgb[k] = (float32(rgbe[k]) + 0.5) * scale
+				}
+			}
+
+			i := (y*width + x) * 4
+
+			for k := 0; k < 3; k++ {
+				pixels[i+k] = float32ToHalf(rgb[k])
+			}
+
+			pixels[i+3] = float32ToHalf(1)
+		}
+	}
+
+	var level bytes.Buffer
+	binary.Write(&level, binary.LittleEndian, pixels)
+
+	return &TextureData{
+		format: wgpu.TextureFormat_RGBA16Float,
+		width:  uint32(width),
+		height: uint32(height),
+		levels: [][]byte{level.Bytes()},
+	}, nil
+}
+
+// scanlines are either flat RGBE, or run-length encoded one component at a time
+
+func readRadianceScanline(reader *bytes.Reader, scanline []byte, width int) error {
+	var start [4]byte
+
+	if _, err := io.ReadFull(reader, start[:]); err != nil {
+		return err
+	}
+
+	if width < 8 || width > 0x7FFF || start[0] != 2 || start[1] != 2 || start[2]&0x80 != 0 {
+		copy(scanline, start[:])
+
+		if _, err := io.ReadFull(reader, scanline[4:]); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	if int(start[2])<<8|int(start[3]) != width {
+		return errors.New("run-length encoded scanline is the wrong width")
+	}
+
+	for k := 0; k < 4; k++ {
+		for x := 0; x < width; {
+			count, err := reader.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			run := count > 128
+
+			if run {
+				count -= 128
+			}
+
+			if count == 0 || x+int(count) > width {
+				return errors.New("run goes past the end of the scanline")
+			}
+
+			if run {
+				value, err := reader.ReadByte()
+				if err != nil {
+					return err
+				}
+
+				for ; count > 0; count-- {
+					scanline[x*4+k] = value
+					x++
+				}
+
+				continue
+			}
+
+			for ; count > 0; count-- {
+				value, err := reader.ReadByte()
+				if err != nil {
+					return err
+				}
+
+				scanline[x*4+k] = value
+				x++
+			}
+		}
+	}
+
+	return nil
+}
+
+// values too large for a half float are clamped to the largest one, and those too small flush to zero
+
+func float32ToHalf(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int32((bits>>23)&0xFF) - 127 + 15
+	mantissa := bits & 0x7FFFFF
+
+	if exp >= 31 {
+		return sign | 0x7BFF
+	}
+
+	if exp <= 0 {
+		if exp < -10 {
+			return sign
+		}
+
+		// subnormal, with the implicit leading 1 made explicit
+
+		mantissa |= 0x800000
+		return sign | uint16(mantissa>>uint32(14-exp))
+	}
+
+	return sign | uint16(exp)<<10 | uint16(mantissa>>13)
+}
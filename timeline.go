@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+//go:embed res/timelines/*.csv
+var timelines_fs embed.FS
+
+// track kinds:
+// - camera: "x y z yaw pitch", interpolated
+// - node: "x y z [yaw]" local transform of the target scene node, interpolated
+// - visible: "0" or "1", whether the target scene node is drawn
+// - dialogue: conversation to start
+// - audio: sound to play
+// - flag: changes to apply to the story flags
+// - world: "name [duration]", transition to another world
+// - hold: stop the timeline there, keeping the camera locked for good
+
+var TIMELINE_TRACK_VALUES = map[string][]int{
+	"camera": {5},
+	"node":   {3, 4},
+}
+
+const TIMELINE_SKIP_KEY = glfw.KeyTab
+
+type TimelineKey struct {
+	time   float32
+	values []float32
+	value  string
+}
+
+type TimelineTrack struct {
+	kind   string
+	target string
+	keys   []*TimelineKey
+}
+
+type Timeline struct {
+	name     string
+	tracks   []*TimelineTrack
+	duration float32
+	hold     float32
+}
+
+func NewTimeline(name string, timeline_csv []byte) (*Timeline, error) {
+	records, err := csv.NewReader(bytes.NewReader(timeline_csv)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	timeline := &Timeline{name: name, hold: -1}
+	tracks := make(map[string]*TimelineTrack)
+
+	for i := 1; i < len(records); i++ {
+		record := records[i]
+
+		if len(record) != 4 {
+			return nil, fmt.Errorf("timeline %q: line %d has %d fields, expected 4", name, i+1, len(record))
+		}
+
+		time, err := strconv.ParseFloat(strings.TrimSpace(record[0]), 32)
+		if err != nil {
+			return nil, fmt.Errorf("timeline %q: line %d: %v", name, i+1, err)
+		}
+
+		kind, target := record[1], record[2]
+		key := &TimelineKey{time: float32(time), value: record[3]}
+
+		switch kind {
+		case "camera", "node", "visible":
+			for _, field := range strings.Fields(record[3]) {
+				value, err := strconv.ParseFloat(field, 32)
+				if err != nil {
+					return nil, fmt.Errorf("timeline %q: line %d: %v", name, i+1, err)
+				}
+
+				key.values = append(key.values, float32(value))
+			}
+
+			if counts, ok := TIMELINE_TRACK_VALUES[kind]; ok {
+				valid := false
+
+				for _, count := range counts {
+					valid = valid || len(key.values) == count
+				}
+
+				if !valid {
+					return nil, fmt.Errorf("timeline %q: line %d: wrong number of values for %s track", name, i+1, kind)
+				}
+			}
+		case "hold":
+			if timeline.hold < 0 || key.time < timeline.hold {
+				timeline.hold = key.time
+			}
+		case "dialogue", "audio", "flag", "world":
+		default:
+			return nil, fmt.Errorf("timeline %q: line %d: unknown track kind %q", name, i+1, kind)
+		}
+
+		if key.time > timeline.duration {
+			timeline.duration = key.time
+		}
+
+		track_name := kind + ":" + target
+		track, ok := tracks[track_name]
+
+		if !ok {
+			track = &TimelineTrack{kind: kind, target: target}
+			tracks[track_name] = track
+			timeline.tracks = append(timeline.tracks, track)
+		}
+
+		track.keys = append(track.keys, key)
+	}
+
+	for _, track := range timeline.tracks {
+		sort.SliceStable(track.keys, func(i, j int) bool {
+			return track.keys[i].time < track.keys[j].time
+		})
+	}
+
+	return timeline, nil
+}
+
+// value of an interpolated track at a given time, clamped to its first and last keys
+
+func (track *TimelineTrack) Sample(time float32) []float32 {
+	keys := track.keys
+
+	if time <= keys[0].time {
+		return keys[0].values
+	}
+
+	for i := 1; i < len(keys); i++ {
+		if time > keys[i].time {
+			continue
+		}
+
+		prev, next := keys[i-1], keys[i]
+		t := (time - prev.time) / (next.time - prev.time)
+		values := make([]float32, len(prev.values))
+
+		for j := range values {
+			values[j] = prev.values[j]
+
+			if j < len(next.values) {
+				values[j] += (next.values[j] - prev.values[j]) * t
+			}
+		}
+
+		return values
+	}
+
+	return keys[len(keys)-1].values
+}
+
+// plays back cutscenes; the player can't move while one is playing
+
+type TimelinePlayer struct {
+	state     *State
+	timelines map[string]*Timeline
+
+	current *Timeline
+	time    float32
+	held    bool
+
+	prev_skip bool
+}
+
+func NewTimelinePlayer(state *State) (*TimelinePlayer, error) {
+	player := &TimelinePlayer{
+		state:     state,
+		timelines: make(map[string]*Timeline),
+	}
+
+	entries, err := timelines_fs.ReadDir("res/timelines")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".csv")
+		src, err := timelines_fs.ReadFile(path.Join("res/timelines", entry.Name()))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if player.timelines[name], err = NewTimeline(name, src); err != nil {
+			return nil, err
+		}
+	}
+
+	return player, nil
+}
+
+func (player *TimelinePlayer) Play(name string) {
+	timeline, ok := player.timelines[name]
+
+	if !ok {
+		log.Printf("Unknown timeline %q\n", name)
+		return
+	}
+
+	log.Printf("Play timeline %q\n", name)
+
+	player.current = timeline
+	player.time = 0
+	player.held = false
+
+	player.fire(-1, 0, true)
+	player.apply()
+}
+
+func (player *TimelinePlayer) Playing() bool {
+	return player.current != nil
+}
+
+// fire events between two points in time, in the order they happen whichever track they're on
+// when skipping, dialogue and audio cues are left out
+
+func (player *TimelinePlayer) fire(from, to float32, cues bool) {
+	state := player.state
+
+	type event struct {
+		track *TimelineTrack
+		key   *TimelineKey
+	}
+
+	var events []event
+
+	for _, track := range player.current.tracks {
+		for _, key := range track.keys {
+			if key.time > from && key.time <= to {
+				events = append(events, event{track, key})
+			}
+		}
+	}
+
+	// keys at the same time fire in the order their tracks were given
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].key.time < events[j].key.time
+	})
+
+	for _, event := range events {
+		track, key := event.track, event.key
+
+		switch track.kind {
+		case "visible":
+			if node, ok := state.nodes[track.target]; ok {
+				node.hidden = len(key.values) > 0 && key.values[0] == 0
+			}
+		case "flag":
+			state.flags.Apply(key.value)
+		case "world":
+			player.transition(key.value)
+		case "dialogue":
+			if cues {
+				state.dialogue.Start(key.value)
+			}
+		case "audio":
+			if cues {
+				playSound(key.value, state)
+			}
+		}
+	}
+}
+
+func (player *TimelinePlayer) transition(value string) {
+	fields := strings.Fields(value)
+
+	if len(fields) == 0 {
+		return
+	}
+
+	duration := float64(0)
+
+	if len(fields) > 1 {
+		var err error
+
+		if duration, err = strconv.ParseFloat(fields[1], 32); err != nil {
+			log.Printf("Invalid world transition duration %q\n", fields[1])
+		}
+	}
+
+	player.state.worlds.Transition(fields[0], float32(duration))
+}
+
+// apply interpolated tracks at the current time
+
+func (player *TimelinePlayer) apply() {
+	state := player.state
+
+	for _, track := range player.current.tracks {
+		switch track.kind {
+		case "camera":
+			values := track.Sample(player.time)
+
+			state.player.pos = [3]float32{values[0], values[1], values[2]}
+			state.player.rot = [2]float32{values[3], values[4]}
+			state.player.vel = [3]float32{}
+			state.player.orientation = NewMat()
+		case "node":
+			node, ok := state.nodes[track.target]
+
+			if !ok {
+				continue
+			}
+
+			values := track.Sample(player.time)
+			local := NewMat().Translation(values[0], values[1], values[2])
+
+			if len(values) > 3 {
+				local.Multiply(NewMat().Rotate(values[3], 0, 1, 0))
+			}
+
+			node.SetLocal(local)
+		}
+	}
+}
+
+// jump to the end (or to the hold point) without playing dialogue or audio cues
+
+func (player *TimelinePlayer) Skip() {
+	if player.current == nil || player.held {
+		return
+	}
+
+	end := player.current.duration
+
+	if player.current.hold >= 0 {
+		end = player.current.hold
+	}
+
+	log.Printf("Skip timeline %q\n", player.current.name)
+
+	player.state.dialogue.Stop()
+	player.advance(end, false)
+}
+
+func (player *TimelinePlayer) advance(to float32, cues bool) {
+	timeline := player.current
+
+	if timeline.hold >= 0 && to >= timeline.hold {
+		to = timeline.hold
+		player.held = true
+	}
+
+	player.fire(player.time, to, cues)
+	player.time = to
+	player.apply()
+
+	if !player.held && player.time >= timeline.duration {
+		player.current = nil
+	}
+}
+
+func (player *TimelinePlayer) Update() {
+	if player.current == nil {
+		return
+	}
+
+	skip := player.state.win.GetKey(TIMELINE_SKIP_KEY) == glfw.Press
+
+	if skip && !player.prev_skip {
+		player.Skip()
+	}
+
+	player.prev_skip = skip
+
+	if player.current == nil {
+		return
+	}
+
+	if player.held {
+		player.apply()
+		return
+	}
+
+	player.advance(player.time+player.state.dt, true)
+}
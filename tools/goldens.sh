@@ -0,0 +1,48 @@
+#!/bin/sh
+# renders each world headlessly and compares it with its golden image in goldens/
+# pass --update to write the golden images instead, once the renders have been checked by eye
+# worlds without a golden image yet are skipped, so run with --update first
+
+set -e
+cd "$(dirname "$0")/.."
+
+go build -o quoicoubeh .
+mkdir -p goldens/out
+
+update=false
+failed=0
+missing=0
+
+if [ "$1" = "--update" ]; then
+	update=true
+fi
+
+# name world camera size flags
+
+check() {
+	output="goldens/out/$1.png"
+	golden="goldens/$1.png"
+
+	if $update; then
+		./quoicoubeh -headless "$golden" -headless-world "$2" -headless-camera "$3" -headless-size "$4" -headless-flags "$5"
+	elif [ ! -f "$golden" ]; then
+		echo "No golden image for $1 ($golden), skipping it" >&2
+		missing=$((missing + 1))
+	elif ! ./quoicoubeh -headless "$output" -headless-world "$2" -headless-camera "$3" -headless-size "$4" -headless-flags "$5" -headless-golden "$golden"; then
+		failed=$((failed + 1))
+	fi
+}
+
+check alexis_room alexis_room 0,0,0,1.5708,0 640x480 ""
+check apat apat 0,0,0,1.5708,0 640x480 ""
+check apat_portal_lit apat 0,0,0,1.5708,0 640x480 portal_lit
+check obama obama -3,0,0,3.1416,0 640x480 ending
+
+if [ $missing -gt 0 ]; then
+	echo "$missing golden images are missing, generate them with $0 --update and check them by eye before committing them" >&2
+fi
+
+if [ $failed -gt 0 ]; then
+	echo "$failed golden images differ, see goldens/out/" >&2
+	exit 1
+fi
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"log"
+
+	"github.com/rajveermalviya/go-webgpu/wgpu"
+)
+
+// per-frame uniforms: each draw pushes its transforms, and binds them with dynamic offsets into one buffer
+// the whole frame's worth is written to the GPU in one go before it's submitted, and the ring starts over next frame
+
+const UNIFORM_RING_CAPACITY = 64 * 1024
+const UNIFORM_MATRIX_SIZE = 64
+
+type UniformRing struct {
+	state *State
+
+	alignment uint64
+	data      []byte
+
+	buffer   *wgpu.Buffer
+	capacity uint64
+
+	// binding 0 is the camera's view/projection matrix, binding 1 the model matrix, both dynamic
+
+	bind_group_layout *SharedLayout
+	bind_group        *wgpu.BindGroup
+}
+
+func NewUniformRing(state *State) (*UniformRing, error) {
+	ring := &UniformRing{
+		state:     state,
+		alignment: uint64(state.device.GetLimits().Limits.MinUniformBufferOffsetAlignment),
+	}
+
+	if ring.alignment == 0 {
+		ring.alignment = 256
+	}
+
+	var err error
+
+	if ring.bind_group_layout, err = NewSharedLayout(state, "Bind group layout (transforms)",
+		[]wgpu.BindGroupLayoutEntry{
+			{ // camera
+				Binding:    0,
+				Visibility: wgpu.ShaderStage_Vertex,
+				Buffer: wgpu.BufferBindingLayout{
+					Type:             wgpu.BufferBindingType_Uniform,
+					HasDynamicOffset: true,
+					MinBindingSize:   UNIFORM_MATRIX_SIZE,
+				},
+			},
+			{ // model
+				Binding:    1,
+				Visibility: wgpu.ShaderStage_Vertex,
+				Buffer: wgpu.BufferBindingLayout{
+					Type:             wgpu.BufferBindingType_Uniform,
+					HasDynamicOffset: true,
+					MinBindingSize:   UNIFORM_MATRIX_SIZE,
+				},
+			},
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if err := ring.allocate(UNIFORM_RING_CAPACITY); err != nil {
+		ring.bind_group_layout.Release()
+		return nil, err
+	}
+
+	return ring, nil
+}
+
+func (ring *UniformRing) allocate(capacity uint64) error {
+	buffer, err := ring.state.device.CreateBuffer(&wgpu.BufferDescriptor{
+		Label: "Uniform ring",
+		Size:  capacity,
+		Usage: wgpu.BufferUsage_Uniform | wgpu.BufferUsage_CopyDst,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	bind_group, err := ring.state.device.CreateBindGroup(&wgpu.BindGroupDescriptor{
+		Label:  "Bind group (transforms)",
+		Layout: ring.bind_group_layout.layout,
+		Entries: []wgpu.BindGroupEntry{
+			{
+				Binding: 0,
+				Buffer:  buffer,
+				Size:    UNIFORM_MATRIX_SIZE,
+			},
+			{
+				Binding: 1,
+				Buffer:  buffer,
+				Size:    UNIFORM_MATRIX_SIZE,
+			},
+		},
+	})
+
+	if err != nil {
+		buffer.Release()
+		return err
+	}
+
+	ring.releaseBuffer()
+
+	ring.buffer = buffer
+	ring.bind_group = bind_group
+	ring.capacity = capacity
+
+	return nil
+}
+
+func (ring *UniformRing) releaseBuffer() {
+	if ring.bind_group != nil {
+		ring.bind_group.Release()
+	}
+
+	if ring.buffer != nil {
+		ring.buffer.Release()
+	}
+}
+
+// push a matrix for this frame, returning the dynamic offset to bind it at
+
+func (ring *UniformRing) Push(mat *Mat) uint32 {
+	offset := uint64(len(ring.data))
+	ring.data = append(ring.data, wgpu.ToBytes(mat.Data[:])...)
+
+	for uint64(len(ring.data))%ring.alignment != 0 {
+		ring.data = append(ring.data, 0)
+	}
+
+	return uint32(offset)
+}
+
+// write everything pushed this frame, growing the buffer if needed
+// this must happen before the frame's passes are encoded, as they bind whichever bind group is current then
+
+func (ring *UniformRing) Flush() {
+	size := uint64(len(ring.data))
+
+	if size > ring.capacity {
+		capacity := ring.capacity
+
+		for capacity < size {
+			capacity *= 2
+		}
+
+		log.Printf("Grow uniform ring to %d bytes\n", capacity)
+
+		if err := ring.allocate(capacity); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if size > 0 {
+		ring.state.queue.WriteBuffer(ring.buffer, 0, ring.data)
+	}
+
+	ring.data = ring.data[:0]
+}
+
+// bind the transforms for a draw
+
+func (ring *UniformRing) Set(render_pass *wgpu.RenderPassEncoder, camera, model uint32) {
+	render_pass.SetBindGroup(1, ring.bind_group, []uint32{camera, model})
+}
+
+func (ring *UniformRing) Release() {
+	ring.releaseBuffer()
+	ring.bind_group_layout.Release()
+}
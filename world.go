@@ -1,5 +1,268 @@
 package main
 
-type World struct {
+import (
+	"fmt"
+	"log"
+)
+
+type World interface {
+	Load() error
+	Update()
+	Render()
+	Release()
+	OnEnter()
+	OnExit()
+}
+
+// worlds the player can collide with
+// inactive worlds keep colliding, as the player falls from one into the other
+
+type CollisionWorld interface {
+	CollisionScene() *SceneNode
+}
+
+// embedded in every world, so they only have to implement what they need
+
+type WorldBase struct {
 	state *State
 }
+
+func (world *WorldBase) Update()  {}
+func (world *WorldBase) OnEnter() {}
+func (world *WorldBase) OnExit()  {}
+
+// world registry, filled in by each world's init function
+
+type WorldConstructor func(state *State) World
+
+var world_names []string
+var world_constructors = make(map[string]WorldConstructor)
+
+func RegisterWorld(name string, constructor WorldConstructor) {
+	if _, ok := world_constructors[name]; ok {
+		panic(fmt.Sprintf("World %q registered twice", name))
+	}
+
+	world_names = append(world_names, name)
+	world_constructors[name] = constructor
+}
+
+// worlds which are active when the game starts, in the order they are drawn
+// other worlds are only seen through portals
+
+var START_WORLDS = []string{"alexis_room"}
+
+type WorldTransition struct {
+	to       string
+	duration float32
+	time     float32
+	switched bool
+}
+
+type WorldManager struct {
+	state  *State
+	worlds map[string]World
+	active []string
+
+	transition *WorldTransition
+}
+
+func NewWorldManager(state *State) (*WorldManager, error) {
+	manager := &WorldManager{
+		state:  state,
+		worlds: make(map[string]World),
+	}
+
+	for _, name := range world_names {
+		log.Printf("Load world %q\n", name)
+
+		world := world_constructors[name](state)
+
+		if err := world.Load(); err != nil {
+			manager.Release()
+			return nil, fmt.Errorf("loading world %q: %w", name, err)
+		}
+
+		manager.worlds[name] = world
+	}
+
+	return manager, nil
+}
+
+func (manager *WorldManager) Get(name string) World {
+	return manager.worlds[name]
+}
+
+func (manager *WorldManager) IsActive(name string) bool {
+	for _, active := range manager.active {
+		if active == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (manager *WorldManager) Active() []string {
+	return append([]string(nil), manager.active...)
+}
+
+// make exactly these worlds active, leaving those which already are alone
+
+func (manager *WorldManager) SetActive(names []string) {
+	for _, active := range manager.Active() {
+		keep := false
+
+		for _, name := range names {
+			keep = keep || name == active
+		}
+
+		if !keep {
+			manager.Deactivate(active)
+		}
+	}
+
+	for _, name := range names {
+		manager.Activate(name)
+	}
+}
+
+// make exactly these worlds active, without entering or exiting any of them, e.g. when rewinding, where going back into a world mustn't replay what happens when it's entered
+// any transition under way is dropped, as it's from the future
+
+func (manager *WorldManager) RestoreActive(names []string) {
+	manager.active = append([]string(nil), names...)
+	manager.transition = nil
+}
+
+// activate a world on top of the currently active ones
+
+func (manager *WorldManager) Activate(name string) {
+	world, ok := manager.worlds[name]
+
+	if !ok {
+		log.Printf("Unknown world %q\n", name)
+		return
+	}
+
+	if manager.IsActive(name) {
+		return
+	}
+
+	manager.active = append(manager.active, name)
+	world.OnEnter()
+	manager.state.events.Emit("world_enter:" + name)
+}
+
+func (manager *WorldManager) Deactivate(name string) {
+	for i, active := range manager.active {
+		if active != name {
+			continue
+		}
+
+		manager.active = append(manager.active[:i], manager.active[i+1:]...)
+		manager.worlds[name].OnExit()
+		manager.state.events.Emit("world_exit:" + name)
+
+		return
+	}
+}
+
+// replace all active worlds by another one
+// the switch happens halfway through, so the first half can fade out and the second half fade back in
+
+func (manager *WorldManager) Transition(to string, duration float32) {
+	if _, ok := manager.worlds[to]; !ok {
+		log.Printf("Unknown world %q\n", to)
+		return
+	}
+
+	manager.transition = &WorldTransition{to: to, duration: duration}
+	manager.updateTransition()
+}
+
+// how far into a transition we are, from 0 (not transitioning) to 1 (halfway, when worlds are switched)
+
+func (manager *WorldManager) TransitionFade() float32 {
+	transition := manager.transition
+
+	if transition == nil || transition.duration <= 0 {
+		return 0
+	}
+
+	progress := transition.time / (transition.duration / 2)
+
+	if progress > 1 {
+		progress = 2 - progress
+	}
+
+	return progress
+}
+
+func (manager *WorldManager) updateTransition() {
+	transition := manager.transition
+
+	if !transition.switched && transition.time >= transition.duration/2 {
+		for len(manager.active) > 0 {
+			manager.Deactivate(manager.active[len(manager.active)-1])
+		}
+
+		manager.Activate(transition.to)
+		transition.switched = true
+	}
+
+	if transition.time >= transition.duration {
+		manager.transition = nil
+	}
+}
+
+func (manager *WorldManager) Update() {
+	if manager.transition != nil {
+		manager.transition.time += manager.state.dt
+		manager.updateTransition()
+	}
+
+	for _, name := range manager.active {
+		manager.worlds[name].Update()
+	}
+}
+
+func (manager *WorldManager) Render() {
+	for _, name := range manager.active {
+		manager.state.portals.RenderWorld(name, manager.worlds[name])
+	}
+}
+
+// nodes with colliders or heightmaps
+
+func (manager *WorldManager) CollisionNodes() []*SceneNode {
+	var nodes []*SceneNode
+
+	for _, name := range world_names {
+		world, ok := manager.worlds[name].(CollisionWorld)
+
+		if !ok {
+			continue
+		}
+
+		world.CollisionScene().Walk(func(node *SceneNode) {
+			if len(node.colliders) > 0 || (node.model != nil && node.model.heightmap != nil) {
+				nodes = append(nodes, node)
+			}
+		})
+	}
+
+	return nodes
+}
+
+func (manager *WorldManager) Release() {
+	for _, name := range manager.active {
+		manager.worlds[name].OnExit()
+	}
+
+	manager.active = nil
+
+	for _, world := range manager.worlds {
+		world.Release()
+	}
+}
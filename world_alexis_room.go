@@ -2,89 +2,161 @@ package main
 
 import (
 	_ "embed"
-
-	"github.com/rajveermalviya/go-webgpu/wgpu"
+	"math"
 )
 
 type WorldAlexisRoom struct {
-	World
-
-	room *Model
-	door *Model
+	WorldBase
 
-	door_opened bool
-	door_angle  float32
+	scene      *SceneNode
+	door_hinge *SceneNode
 
-	sink_activated bool
-	should_draw bool
+	door_angle float32
+	daylight   *Light
 }
 
-//go:embed res/alexis-room-lightmap.png
-var alexis_room_lightmap []byte
-
 //go:embed res/alexis-room.ivx
 var alexis_room []byte
 
 //go:embed res/alexis-door.ivx
 var alexis_door []byte
 
-func NewWorldAlexisRoom(state *State) (*WorldAlexisRoom, error) {
-	room := &WorldAlexisRoom{}
-	room.World = World{state: state}
+//go:embed tools/alexis_room.csv
+var alexis_room_csv []byte
+
+func init() {
+	RegisterWorld("alexis_room", NewWorldAlexisRoom)
+}
+
+func NewWorldAlexisRoom(state *State) World {
+	return &WorldAlexisRoom{WorldBase: WorldBase{state: state}}
+}
+
+var DOOR_ORIGIN = [3]float32{2.856, 2.4643, 0.8}
+
+// the doorway is a portal onto the same spot of the Apat, which is why walking through it drops the player into the Apat
 
-	room.sink_activated = false
-	room.should_draw = true
+var DOOR_PORTAL_CENTRE = [3]float32{3.1 * M_TO_AYLIN, 1.23 * M_TO_AYLIN, 0.8 * M_TO_AYLIN}
+var DOOR_PORTAL_SIZE = [2]float32{0.99, 2.46}
 
-	var err error
+// daylight from the Apat shines in through the doorway once the door is open, so the door casts a shadow into the room
 
-	if room.room, err = NewModelFromIvx(state, "Alexis room", alexis_room, alexis_room_lightmap, false); err != nil {
-		return nil, err
+var DAYLIGHT_POSITION = [3]float32{4.6 * M_TO_AYLIN, 2 * M_TO_AYLIN, 0.8 * M_TO_AYLIN}
+var DAYLIGHT_DIRECTION = [3]float32{-1, -0.3, 0}
+var DAYLIGHT_COLOUR = [3]float32{1, 0.95, 0.8}
+
+const DAYLIGHT_INTENSITY = 0.6
+const DAYLIGHT_RADIUS = 8
+
+func (world *WorldAlexisRoom) Load() error {
+	state := world.state
+
+	room, err := NewModelFromIvx(state, "Alexis room", alexis_room, "alexis_room", false)
+	if err != nil {
+		return err
 	}
 
-	if room.door, err = NewModelFromIvx(state, "Alexis door", alexis_door, alexis_room_lightmap, false); err != nil {
-		room.room.Release()
-		return nil, err
+	door, err := NewModelFromIvx(state, "Alexis door", alexis_door, "alexis_room", false)
+	if err != nil {
+		room.Release()
+		return err
 	}
 
-	return room, nil
-}
+	// the door's collider swings open with it
 
-var DOOR_ORIGIN = [3]float32{2.856, 2.4643, 0.8}
+	var room_colliders, door_colliders []Collider
 
-func (world *WorldAlexisRoom) Render() {
-	if !world.should_draw {
-		return
+	for _, collider := range GetCollidersFromCsv(alexis_room_csv) {
+		if collider.name == "Col_Door" {
+			door_colliders = append(door_colliders, collider)
+		} else {
+			room_colliders = append(room_colliders, collider)
+		}
 	}
 
-	world.state.player.mvp(NewMat())
+	world.scene = NewSceneNode(state, "alexis_room", nil)
+
+	NewSceneNode(state, "Alexis room", world.scene).
+		SetModel(room).
+		AddColliders(room_colliders)
+
+	// the door rotates around its hinge
+
+	world.door_hinge = NewSceneNode(state, "Alexis door hinge", world.scene)
 
-	world.state.render_pass_manager.Begin(wgpu.LoadOp_Load, wgpu.LoadOp_Load)
-	render_pass := world.state.render_pass_manager.render_pass
-	world.room.Draw(render_pass)
-	world.state.render_pass_manager.End()
+	NewSceneNode(state, "Alexis door", world.door_hinge).
+		SetLocal(NewMat().Translation(-DOOR_ORIGIN[0]*M_TO_AYLIN, -DOOR_ORIGIN[1]*M_TO_AYLIN, -DOOR_ORIGIN[2]*M_TO_AYLIN)).
+		SetModel(door).
+		AddColliders(door_colliders)
 
+	// the doorway faces into the room
+
+	door_portal := NewSceneNode(state, "Alexis door portal", world.scene).
+		SetLocal(NewPortalMat(DOOR_PORTAL_CENTRE, [3]float32{-1, 0, 0}, [3]float32{0, 1, 0}))
+
+	if _, err := NewPortal(state, "alexis_door", "alexis_room", door_portal, DOOR_PORTAL_SIZE[0], DOOR_PORTAL_SIZE[1], "apat_door"); err != nil {
+		room.Release()
+		door.Release()
+		return err
+	}
+
+	world.daylight = state.lights.Add(&Light{
+		kind:      LIGHT_SPOT,
+		world:     "alexis_room",
+		position:  DAYLIGHT_POSITION,
+		direction: DAYLIGHT_DIRECTION,
+		colour:    DAYLIGHT_COLOUR,
+		intensity: DAYLIGHT_INTENSITY,
+		radius:    DAYLIGHT_RADIUS,
+		inner:     math.Pi / 8,
+		outer:     math.Pi / 4,
+		shadows:   true,
+		casters:   world.scene,
+	})
+
+	return nil
+}
+
+func (world *WorldAlexisRoom) Update() {
 	target_door_angle := float32(0)
 
-	if world.door_opened {
+	if world.state.flags.Check("door_opened") {
 		target_door_angle = -3.14 / 5 * 3
 	}
 
 	world.door_angle += (target_door_angle - world.door_angle) * world.state.dt * 3
 
-	door_mat := NewMat()
-	door_mat.Multiply(NewMat().Translation(DOOR_ORIGIN[0]*M_TO_AYLIN, DOOR_ORIGIN[1]*M_TO_AYLIN, DOOR_ORIGIN[2]*M_TO_AYLIN))
-	door_mat.Multiply(NewMat().Rotate(world.door_angle, 0, 0, 1))
-	door_mat.Multiply(NewMat().Translation(-DOOR_ORIGIN[0]*M_TO_AYLIN, -DOOR_ORIGIN[1]*M_TO_AYLIN, -DOOR_ORIGIN[2]*M_TO_AYLIN))
+	hinge_mat := NewMat().Translation(DOOR_ORIGIN[0]*M_TO_AYLIN, DOOR_ORIGIN[1]*M_TO_AYLIN, DOOR_ORIGIN[2]*M_TO_AYLIN)
+	hinge_mat.Multiply(NewMat().Rotate(world.door_angle, 0, 0, 1))
+
+	world.door_hinge.SetLocal(hinge_mat)
+	world.daylight.enabled = world.door_angle < -0.01
+}
+
+// the door swings open on its own time, so its angle is rewound along with everything else
 
-	world.state.player.mvp(door_mat)
+func (world *WorldAlexisRoom) Snapshot() any {
+	return world.door_angle
+}
 
-	world.state.render_pass_manager.Begin(wgpu.LoadOp_Load, wgpu.LoadOp_Load)
-	render_pass = world.state.render_pass_manager.render_pass
-	world.door.Draw(render_pass)
-	world.state.render_pass_manager.End()
+func (world *WorldAlexisRoom) Restore(snapshot any) {
+	world.door_angle = snapshot.(float32)
+}
+
+func (world *WorldAlexisRoom) Render() {
+	world.scene.Render(true)
+}
+
+func (world *WorldAlexisRoom) CollisionScene() *SceneNode {
+	return world.scene
 }
 
 func (world *WorldAlexisRoom) Release() {
-	world.room.Release()
-	world.door.Release()
+	if world.daylight != nil {
+		world.state.lights.Remove(world.daylight)
+	}
+
+	if world.scene != nil {
+		world.scene.ReleaseModels()
+	}
 }
@@ -2,26 +2,22 @@ package main
 
 import (
 	_ "embed"
-
-	"github.com/rajveermalviya/go-webgpu/wgpu"
+	"math"
 )
 
 type WorldApat struct {
-	World
-
-	landscape *Model
-	portal    *Model
-	ukulele   *Model
+	WorldBase
 
-	ukulele_picked_up bool
-	portal_lit        bool
+	scene     *SceneNode
+	landscape *SceneNode
+	portal    *SceneNode
+	frame     *SceneNode
+	ukulele   *SceneNode
 
-	ukulele_activated bool
+	glow *Light
+	sun  *Light
 }
 
-//go:embed res/apat-lightmap.png
-var apat_lightmap []byte
-
 //go:embed res/apat-landscape.ivx
 var apat_landscape []byte
 
@@ -31,58 +27,208 @@ var apat_portal []byte
 //go:embed res/apat-ukulele.ivx
 var apat_ukulele []byte
 
-func NewWorldApat(state *State) (*WorldApat, error) {
-	apat := &WorldApat{}
-	apat.World = World{state: state}
+//go:embed tools/apat.csv
+var apat_csv []byte
+
+func init() {
+	RegisterWorld("apat", NewWorldApat)
+}
+
+// the Nether portal leads to Obama's room once it's lit, facing the way the player comes from
+
+var NETHER_PORTAL_CENTRE = [3]float32{-3 * M_TO_AYLIN, 6.024 * M_TO_AYLIN, 13.2 * M_TO_AYLIN}
+var NETHER_PORTAL_SIZE = [2]float32{2.42, 3.63}
 
-	apat.ukulele_activated = false
+// glow of the lit portal, which flickers around its base intensity
+// its colour is the emissive colour of the portal's material, so it matches the portal
 
-	var err error
+const NETHER_GLOW_INTENSITY = 1.5
+const NETHER_GLOW_RADIUS = 8
 
-	if apat.landscape, err = NewModelFromIvx(state, "Apat landscape", apat_landscape, apat_lightmap, true); err != nil {
-		return nil, err
+// the portal's frame is made of obsidian blocks around it, all drawn at once as instances of the same block
+// blocks are a bit bigger than the portal is high divided by 3, so they go just around what's baked into the landscape
+
+const NETHER_BLOCK_SIZE = 1.22
+
+// sunlight over the Apat, on top of what's baked into its lightmap, so the ukulele and the portal cast shadows onto the landscape
+
+var SUN_DIRECTION = [3]float32{-0.4, -1, 0.3}
+var SUN_COLOUR = [3]float32{1, 0.95, 0.85}
+
+const SUN_INTENSITY = 0.4
+
+func NewWorldApat(state *State) World {
+	return &WorldApat{WorldBase: WorldBase{state: state}}
+}
+
+func (world *WorldApat) Load() error {
+	state := world.state
+
+	landscape, err := NewModelFromIvx(state, "Apat landscape", apat_landscape, "apat", true)
+	if err != nil {
+		return err
+	}
+
+	portal, err := NewModelFromIvx(state, "Apat portal", apat_portal, "nether_portal", false)
+	if err != nil {
+		landscape.Release()
+		return err
 	}
 
-	apat.landscape.ColliderOffset(0, -10, 0)
+	ukulele, err := NewModelFromIvx(state, "Apat ukulele", apat_ukulele, "apat", false)
+	if err != nil {
+		landscape.Release()
+		portal.Release()
+		return err
+	}
 
-	if apat.portal, err = NewModelFromIvx(state, "Apat portal", apat_portal, apat_lightmap, false); err != nil {
-		apat.landscape.Release()
-		return nil, err
+	block, err := NewBoxModel(state, "Obsidian block", "obsidian")
+	if err != nil {
+		landscape.Release()
+		portal.Release()
+		ukulele.Release()
+		return err
 	}
 
-	if apat.ukulele, err = NewModelFromIvx(state, "Apat ukulele", apat_ukulele, apat_lightmap, false); err != nil {
-		apat.landscape.Release()
-		apat.portal.Release()
-		return nil, err
+	// the Apat is right below Alexis' room
+
+	world.scene = NewSceneNode(state, "apat", nil).SetLocal(NewMat().Translation(0, -10, 0))
+
+	world.landscape = NewSceneNode(state, "Apat landscape", world.scene).
+		SetModel(landscape).
+		AddColliders(GetCollidersFromCsv(apat_csv))
+
+	world.portal = NewSceneNode(state, "Apat portal", world.scene).SetModel(portal)
+	world.ukulele = NewSceneNode(state, "Apat ukulele", world.scene).SetModel(ukulele)
+
+	world.frame = NewSceneNode(state, "Nether portal frame", world.scene).
+		SetModel(block).
+		SetInstances(netherFrame(state))
+
+	// other side of Alexis' doorway, facing out into the Apat
+
+	door_portal := NewSceneNode(state, "Apat door portal", world.scene).
+		SetLocal(NewMat().Translation(0, 10, 0).Multiply(NewPortalMat(DOOR_PORTAL_CENTRE, [3]float32{1, 0, 0}, [3]float32{0, 1, 0})))
+
+	if _, err := NewPortal(state, "apat_door", "apat", door_portal, DOOR_PORTAL_SIZE[0], DOOR_PORTAL_SIZE[1], "alexis_door"); err != nil {
+		world.scene.ReleaseModels()
+		return err
 	}
 
-	apat.ukulele_picked_up = false
-	apat.portal_lit = false
+	// the Nether portal node is under the portal model's, so it can only be seen and gone through once lit
 
-	return apat, nil
+	nether_portal := NewSceneNode(state, "Nether portal", world.portal).
+		SetLocal(NewPortalMat(NETHER_PORTAL_CENTRE, [3]float32{0, 0, -1}, [3]float32{0, 1, 0}))
+
+	if _, err := NewPortal(state, "nether", "apat", nether_portal, NETHER_PORTAL_SIZE[0], NETHER_PORTAL_SIZE[1], "obama_entrance"); err != nil {
+		world.scene.ReleaseModels()
+		return err
+	}
+
+	// just in front of the portal, and only on while it's visible, i.e. once it's lit
+
+	var glow_colour [3]float32
+	copy(glow_colour[:], portal.material.Param("emissive"))
+
+	world.glow = state.lights.Add(&Light{
+		kind:      LIGHT_POINT,
+		node:      nether_portal,
+		position:  [3]float32{0, 0, 1},
+		colour:    glow_colour,
+		intensity: NETHER_GLOW_INTENSITY,
+		radius:    NETHER_GLOW_RADIUS,
+		enabled:   true,
+	})
+
+	world.sun = state.lights.Add(&Light{
+		kind:      LIGHT_DIRECTIONAL,
+		world:     "apat",
+		direction: SUN_DIRECTION,
+		colour:    SUN_COLOUR,
+		intensity: SUN_INTENSITY,
+		enabled:   true,
+		shadows:   true,
+		casters:   world.scene,
+	})
+
+	// the serenade is what lights the portal
+
+	state.events.On("dialogue_done:ukulele5", func() {
+		state.flags.Apply("portal_lit")
+	})
+
+	return nil
 }
 
-func (world *WorldApat) Render() {
-	world.state.player.mvp(NewMat().Translation(0, world.landscape.collider_off_y, 0))
+// blocks going around the portal, 4 across and 5 high like in Minecraft, in the portal model's space (i.e. in metres)
+
+func netherFrame(state *State) *Instances {
+	frame := NewInstances(state, "Nether portal frame")
+
+	centre := [3]float32{NETHER_PORTAL_CENTRE[0] / M_TO_AYLIN, NETHER_PORTAL_CENTRE[1] / M_TO_AYLIN, NETHER_PORTAL_CENTRE[2] / M_TO_AYLIN}
+	step := NETHER_PORTAL_SIZE[0] / 2
+	left := centre[0] - NETHER_PORTAL_SIZE[0]/2 - step/2
+	bottom := centre[1] - NETHER_PORTAL_SIZE[1]/2 - step/2
 
-	world.state.render_pass_manager.Begin(wgpu.LoadOp_Clear, wgpu.LoadOp_Clear)
-	render_pass := world.state.render_pass_manager.render_pass
+	for column := 0; column < 4; column++ {
+		for row := 0; row < 5; row++ {
+			inside := column > 0 && column < 3 && row > 0 && row < 4
 
-	world.landscape.Draw(render_pass)
+			if inside {
+				continue
+			}
 
-	if world.portal_lit {
-		world.portal.Draw(render_pass)
+			x := left + float32(column)*step
+			y := bottom + float32(row)*step
+
+			transform := NewMat().Translation(x, y, centre[2]).Multiply(NewMat().Scale(NETHER_BLOCK_SIZE, NETHER_BLOCK_SIZE, NETHER_BLOCK_SIZE))
+			frame.Add(transform, INSTANCE_IDENTITY.tint)
+		}
 	}
 
-	if !world.ukulele_picked_up {
-		world.ukulele.Draw(render_pass)
+	return frame
+}
+
+func (world *WorldApat) Update() {
+	lit := world.state.flags.Check("portal_lit")
+
+	world.portal.hidden = !lit
+	world.ukulele.hidden = world.state.flags.Check("ukulele_picked_up")
+
+	// a few out-of-phase sines are irregular enough to look like a flicker
+
+	t := world.state.prev_time
+	flicker := 0.15*math.Sin(t*7.3) + 0.1*math.Sin(t*13.1+1.7) + 0.05*math.Sin(t*29.7+4.2)
+
+	world.glow.intensity = NETHER_GLOW_INTENSITY * float32(1+flicker)
+
+	// the portal's surface only lets the player through once lit
+
+	for i := range world.landscape.colliders {
+		if world.landscape.colliders[i].name == "Col_Purple" {
+			world.landscape.colliders[i].ignore = lit
+		}
 	}
+}
+
+func (world *WorldApat) Render() {
+	world.scene.Render(true)
+}
 
-	world.state.render_pass_manager.End()
+func (world *WorldApat) CollisionScene() *SceneNode {
+	return world.scene
 }
 
 func (world *WorldApat) Release() {
-	world.landscape.Release()
-	world.portal.Release()
-	world.ukulele.Release()
+	if world.glow != nil {
+		world.state.lights.Remove(world.glow)
+	}
+
+	if world.sun != nil {
+		world.state.lights.Remove(world.sun)
+	}
+
+	if world.scene != nil {
+		world.scene.ReleaseModels()
+	}
 }
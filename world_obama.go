@@ -2,48 +2,63 @@ package main
 
 import (
 	_ "embed"
-
-	"github.com/rajveermalviya/go-webgpu/wgpu"
 )
 
 type WorldObama struct {
-	World
-	room *Model
-	should_draw bool
+	WorldBase
+	scene *SceneNode
 }
 
-//go:embed res/obama-lightmap.png
-var obama_room_lightmap []byte
-
 //go:embed res/obama-room.ivx
 var obama_room []byte
 
-func NewWorldObama(state *State) (*WorldObama, error) {
-	room := &WorldObama{}
-	room.World = World{state: state}
+func init() {
+	RegisterWorld("obama", NewWorldObama)
+}
 
-	var err error
+// the other side of the Nether portal, in the room's back wall
+// the bottom of the portal lines up with the floor, so the player comes out on it
 
-	if room.room, err = NewModelFromIvx(state, "Obama room", obama_room, obama_room_lightmap, false); err != nil {
-		return nil, err
-	}
+var OBAMA_ENTRANCE_CENTRE = [3]float32{-3.9 * M_TO_AYLIN, 1.66 * M_TO_AYLIN, 0}
 
-	return room, nil
+func NewWorldObama(state *State) World {
+	return &WorldObama{WorldBase: WorldBase{state: state}}
 }
 
-func (world *WorldObama) Render() {
-	if !world.should_draw {
-		return
+func (world *WorldObama) Load() error {
+	room, err := NewModelFromIvx(world.state, "Obama room", obama_room, "obama_room", false)
+	if err != nil {
+		return err
+	}
+
+	world.scene = NewSceneNode(world.state, "obama", nil)
+	NewSceneNode(world.state, "Obama room", world.scene).SetModel(room)
+
+	entrance := NewSceneNode(world.state, "Obama entrance", world.scene).
+		SetLocal(NewPortalMat(OBAMA_ENTRANCE_CENTRE, [3]float32{1, 0, 0}, [3]float32{0, 1, 0}))
+
+	if _, err := NewPortal(world.state, "obama_entrance", "obama", entrance, NETHER_PORTAL_SIZE[0], NETHER_PORTAL_SIZE[1], "nether"); err != nil {
+		room.Release()
+		return err
 	}
 
-	world.state.player.mvp(NewMat())
+	// coming through the Nether portal is what ends the game
 
-	world.state.render_pass_manager.Begin(wgpu.LoadOp_Clear, wgpu.LoadOp_Clear)
-	render_pass := world.state.render_pass_manager.render_pass
-	world.room.Draw(render_pass)
-	world.state.render_pass_manager.End()
+	world.state.events.On("world_enter:obama", func() {
+		if world.state.flags.Check("ukulele_picked_up !ending") {
+			world.state.timeline.Play("outro")
+		}
+	})
+
+	return nil
+}
+
+func (world *WorldObama) Render() {
+	world.scene.Render(true)
 }
 
 func (world *WorldObama) Release() {
-	world.room.Release()
+	if world.scene != nil {
+		world.scene.ReleaseModels()
+	}
 }